@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// Codes of the CCITT T.4/T.6 Standard as Bit Strings, the Index of
+// the terminating Codes is the Run Length
+var (
+	ccittWhiteTerminating = [64]string{
+		"00110101", "000111", "0111", "1000", "1011", "1100", "1110", "1111",
+		"10011", "10100", "00111", "01000", "001000", "000011", "110100", "110101",
+		"101010", "101011", "0100111", "0001100", "0001000", "0010111", "0000011", "0000100",
+		"0101000", "0101011", "0010011", "0100100", "0011000", "00000010", "00000011", "00011010",
+		"00011011", "00010010", "00010011", "00010100", "00010101", "00010110", "00010111", "00101000",
+		"00101001", "00101010", "00101011", "00101100", "00101101", "00000100", "00000101", "00001010",
+		"00001011", "01010010", "01010011", "01010100", "01010101", "00100100", "00100101", "01011000",
+		"01011001", "01011010", "01011011", "01001010", "01001011", "00110010", "00110011", "00110100",
+	}
+	ccittBlackTerminating = [64]string{
+		"0000110111", "010", "11", "10", "011", "0011", "0010", "00011",
+		"000101", "000100", "0000100", "0000101", "0000111", "00000100", "00000111", "000011000",
+		"0000010111", "0000011000", "0000001000", "00001100111", "00001101000", "00001101100", "00000110111", "00000101000",
+		"00000010111", "00000011000", "000011001010", "000011001011", "000011001100", "000011001101", "000001101000", "000001101001",
+		"000001101010", "000001101011", "000011010010", "000011010011", "000011010100", "000011010101", "000011010110", "000011010111",
+		"000001101100", "000001101101", "000011011010", "000011011011", "000001010100", "000001010101", "000001010110", "000001010111",
+		"000001100100", "000001100101", "000001010010", "000001010011", "000000100100", "000000110111", "000000111000", "000000100111",
+		"000000101000", "000001011000", "000001011001", "000000101011", "000000101100", "000001011010", "000001100110", "000001100111",
+	}
+	// make-up Codes for 64 up to 1728, the Index is Run Length / 64 - 1
+	ccittWhiteMakeup = [27]string{
+		"11011", "10010", "010111", "0110111", "00110110", "00110111", "01100100", "01100101",
+		"01101000", "01100111", "011001100", "011001101", "011010010", "011010011", "011010100", "011010101",
+		"011010110", "011010111", "011011000", "011011001", "011011010", "011011011", "010011000", "010011001",
+		"010011010", "011000", "010011011",
+	}
+	ccittBlackMakeup = [27]string{
+		"0000001111", "000011001000", "000011001001", "000001011011", "000000110011", "000000110100", "000000110101", "0000001101100",
+		"0000001101101", "0000001001010", "0000001001011", "0000001001100", "0000001001101", "0000001110010", "0000001110011", "0000001110100",
+		"0000001110101", "0000001110110", "0000001110111", "0000001010010", "0000001010011", "0000001010100", "0000001010101", "0000001011010",
+		"0000001011011", "0000001100100", "0000001100101",
+	}
+	// make-up Codes for 1792 up to 2560 shared by both Colors
+	ccittExtendedMakeup = [13]string{
+		"00000001000", "00000001100", "00000001101", "000000010010", "000000010011", "000000010100", "000000010101",
+		"000000010110", "000000010111", "000000011100", "000000011101", "000000011110", "000000011111",
+	}
+	// vertical Mode by a1 - b1 + 3
+	ccittVertical = [7]string{"0000010", "000010", "010", "1", "011", "000011", "0000011"}
+)
+
+const (
+	ccittPass = "0001"
+	ccittHorizontal = "001"
+	ccittEOL = "000000000001"
+)
+
+// bitWriter collects Bits MSB first
+type bitWriter struct {
+	buf []byte
+	n uint
+}
+
+func (w *bitWriter) write(code string) {
+	for _, bit := range code {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if bit == '1' {
+			w.buf[len(w.buf)-1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+// writeRun writes the Codes of a Run of black or white Pixels
+func (w *bitWriter) writeRun(run int, black bool) {
+	terminating, makeup := ccittWhiteTerminating, ccittWhiteMakeup
+	if black {
+		terminating, makeup = ccittBlackTerminating, ccittBlackMakeup
+	}
+	for run >= 2624 {
+		w.write(ccittExtendedMakeup[len(ccittExtendedMakeup)-1])
+		run -= 2560
+	}
+	if run >= 64 {
+		n := run / 64
+		if n <= len(makeup) {
+			w.write(makeup[n-1])
+		} else {
+			w.write(ccittExtendedMakeup[n-len(makeup)-1])
+		}
+		run -= n * 64
+	}
+	w.write(terminating[run])
+}
+
+// bilevel reports whether img holds black and white Pixels only,
+// as scanned in the bw ColorMode, and returns it as Gray Image
+func bilevel(img image.Image) (*image.Gray, bool) {
+	if gray, ok := img.(*image.Gray); ok {
+		for _, y := range gray.Pix {
+			if y != 0 && y != 0xff {
+				return nil, false
+			}
+		}
+		return gray, true
+	}
+
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			if c.Y != 0 && c.Y != 0xff {
+				return nil, false
+			}
+			gray.SetGray(x, y, c)
+		}
+	}
+	return gray, true
+}
+
+// encodeG4 compresses the bilevel Image with CCITT Group 4 (T.6),
+// Pixels darker than 50% are black
+func encodeG4(img *image.Gray) []byte {
+	b := img.Bounds()
+	width := b.Dx()
+	// the imaginary Line above the Image is white
+	ref := make([]bool, width)
+	cur := make([]bool, width)
+	w := &bitWriter{}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := range cur {
+			cur[x] = img.GrayAt(b.Min.X+x, y).Y < 0x80
+		}
+		encodeG4Line(w, ref, cur)
+		ref, cur = cur, ref
+	}
+
+	// End of Facsimile Block
+	w.write(ccittEOL)
+	w.write(ccittEOL)
+	return w.buf
+}
+
+// encodeG4Line codes the Line cur relative to the Line ref above,
+// true is a black Pixel
+func encodeG4Line(w *bitWriter, ref []bool, cur []bool) {
+	width := len(cur)
+	// first Position from start whose Pixel differs from black
+	findDiff := func(line []bool, start int, black bool) int {
+		for i := start; i < width; i++ {
+			if line[i] != black {
+				return i
+			}
+		}
+		return width
+	}
+	pixel := func(line []bool, i int) bool {
+		return i < width && line[i]
+	}
+
+	a0 := 0
+	a1 := findDiff(cur, 0, false)
+	b1 := findDiff(ref, 0, false)
+	for {
+		b2 := findDiff(ref, b1, pixel(ref, b1))
+		switch d := b1 - a1; {
+		case b2 < a1:
+			w.write(ccittPass)
+			a0 = b2
+		case d >= -3 && d <= 3:
+			w.write(ccittVertical[a1-b1+3])
+			a0 = a1
+		default:
+			a2 := findDiff(cur, a1, pixel(cur, a1))
+			w.write(ccittHorizontal)
+			// a0 is imaginary and white in front of the Line
+			black := a0+a1 != 0 && pixel(cur, a0)
+			w.writeRun(a1-a0, black)
+			w.writeRun(a2-a1, !black)
+			a0 = a2
+		}
+		if a0 >= width {
+			return
+		}
+		black := pixel(cur, a0)
+		a1 = findDiff(cur, a0, black)
+		b1 = findDiff(ref, a0, !black)
+		b1 = findDiff(ref, b1, black)
+	}
+}
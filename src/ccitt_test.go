@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/ccitt"
+)
+
+func TestEncodeG4RoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// wide enough for Runs exceeding the make-up Codes
+	img := image.NewGray(image.Rect(0, 0, 3000, 60))
+	for y := 0; y < 60; y++ {
+		black := false
+		for x := 0; x < 3000; x++ {
+			switch {
+			case y < 10:
+				// white and black Lines across the whole width
+				black = y%2 == 1
+			case rnd.Intn(20) == 0:
+				black = !black
+			}
+			if !black {
+				img.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+
+	decoded := image.NewGray(img.Bounds())
+	err := ccitt.DecodeIntoGray(decoded, bytes.NewReader(encodeG4(img)), ccitt.MSB, ccitt.Group4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Pix, img.Pix) {
+		t.Fatal("decoded image differs")
+	}
+}
+
+func TestBilevel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.White)
+	img.Set(1, 0, color.Black)
+	gray, ok := bilevel(img)
+	if !ok || gray.Pix[0] != 0xff || gray.Pix[1] != 0 {
+		t.Fatal("expected black and white image")
+	}
+	img.Set(1, 0, color.RGBA{R: 0xff, A: 0xff})
+	if _, ok := bilevel(img); ok {
+		t.Fatal("expected color image")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+)
+
+// ColorMode is the Color Mode vocabulary of scanbridge. Each
+// Backend has its own Names, see escl and sane.
+type ColorMode string
+
+const (
+	ColorModeColor ColorMode = "color"
+	ColorModeGray  ColorMode = "gray"
+	ColorModeBw    ColorMode = "bw"
+)
+
+// colorModeNames maps the lowercased Names used by eSCL, the
+// TXT-Record, SANE and former API Versions to the ColorMode
+var colorModeNames = map[string]ColorMode{
+	"color": ColorModeColor,
+	"colour": ColorModeColor,
+	"rgb24": ColorModeColor,
+	"rgb48": ColorModeColor,
+	"gray": ColorModeGray,
+	"grey": ColorModeGray,
+	"grayscale": ColorModeGray,
+	"grayscale8": ColorModeGray,
+	"grayscale16": ColorModeGray,
+	"bw": ColorModeBw,
+	"binary": ColorModeBw,
+	"lineart": ColorModeBw,
+	"blackandwhite1": ColorModeBw,
+}
+
+// parseColorMode translates a Backend specific Name
+func parseColorMode(name string) (ColorMode, bool) {
+	mode, ok := colorModeNames[strings.ToLower(name)]
+	return mode, ok
+}
+
+// normalizeColorModes translates the Names and drops duplicates
+// and unknown ones
+func normalizeColorModes(names []string) []ColorMode {
+	modes := []ColorMode{}
+	for _, name := range names {
+		if mode, ok := parseColorMode(name); ok {
+			modes = appendMissing(modes, mode)
+		}
+	}
+	return modes
+}
+
+// joinColorModes joins the ColorModes by "," like all Lists
+// of the ValidationErrors
+func joinColorModes(modes []ColorMode) string {
+	names := make([]string, len(modes))
+	for i, mode := range modes {
+		names[i] = string(mode)
+	}
+	return strings.Join(names, ",")
+}
+
+// escl returns the scan:ColorMode of the ColorMode
+func (cm ColorMode) escl() string {
+	switch cm {
+	case ColorModeGray:
+		return "Grayscale8"
+	case ColorModeBw:
+		return "BlackAndWhite1"
+	}
+	return "RGB24"
+}
+
+// sane returns the scanimage --mode of the ColorMode
+func (cm ColorMode) sane() string {
+	switch cm {
+	case ColorModeGray:
+		return "Gray"
+	case ColorModeBw:
+		return "Lineart"
+	}
+	return "Color"
+}
+
+// UnmarshalText accepts all known Names, e.g. RGB24 or Lineart
+func (cm *ColorMode) UnmarshalText(text []byte) error {
+	mode, ok := parseColorMode(string(text))
+	if !ok {
+		// kept as is, Validate reports it
+		mode = ColorMode(text)
+	}
+	*cm = mode
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestColorModeIsTranslatedPerBackend(t *testing.T) {
+	for name, expected := range map[string]ColorMode{
+		"RGB24": ColorModeColor,
+		"Color": ColorModeColor,
+		"grayscale": ColorModeGray,
+		"Grayscale8": ColorModeGray,
+		"binary": ColorModeBw,
+		"Lineart": ColorModeBw,
+		"BlackAndWhite1": ColorModeBw,
+	} {
+		if mode, ok := parseColorMode(name); !ok || mode != expected {
+			t.Fatalf("expected %s for %s, got %s", expected, name, mode)
+		}
+	}
+
+	if ColorModeGray.escl() != "Grayscale8" || ColorModeBw.sane() != "Lineart" {
+		t.Fatal("unexpected backend names")
+	}
+
+	var req jobRequest
+	if err := json.Unmarshal([]byte(`{"mode": "Lineart"}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Mode != ColorModeBw {
+		t.Fatalf("expected bw, got %s", req.Mode)
+	}
+}
+
+func TestColorModeErrorsAreJoined(t *testing.T) {
+	caps := &InputSourceCaps{ColorModes: []ColorMode{ColorModeColor, ColorModeGray}}
+	errs := caps.validate(&ScanSettingsDto{ColorMode: ColorModeBw})
+	if len(errs) == 0 || errs[0].Message != "unsupported ColorMode bw, supported are: color,gray" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
@@ -2,17 +2,51 @@ package main
 
 import (
 	"encoding/json"
+	"image/jpeg"
 	"net/url"
 	"os"
+	"time"
 )
 
 type Config struct {
 	IsAutodiscovery bool `json:"isAutodiscovery"`
 	Devices []*ScanDevice `json:"devices"`
 	Smtp *SmtpConfig `json:"smtp"`
+	// path to the scanimage binary, used by Devices of the "sane" Backend
+	Scanimage string `json:"scanimage"`
+	// file the Certificate Fingerprints of eSCL-Devices are
+	// pinned in on first use, memory only if empty
+	TrustStore string `json:"trustStore"`
+	// quality (1-100) of gray and color Pages compressed as JPEG
+	// by scanbridge, jpeg.DefaultQuality if unset
+	JpegQuality int `json:"jpegQuality"`
+	// path to the tesseract binary, which recognizes the Text of
+	// Devices without OCR. Looked up in the PATH if empty.
+	Tesseract string `json:"tesseract"`
+	// seconds an eSCL-Device may take to scan a single Page,
+	// e.g. a slow first Page of the ADF, defaultPageTimeout if unset
+	PageTimeout int `json:"pageTimeout"`
 	IsDebug bool
 }
 
+// jpegQuality returns the configured JPEG quality, falling back
+// to jpeg.DefaultQuality if unset or out of range
+func (c *Config) jpegQuality() int {
+	if c.JpegQuality < 1 || c.JpegQuality > 100 {
+		return jpeg.DefaultQuality
+	}
+	return c.JpegQuality
+}
+
+// pageTimeout returns the configured PageTimeout, falling back
+// to defaultPageTimeout if unset
+func (c *Config) pageTimeout() time.Duration {
+	if c.PageTimeout <= 0 {
+		return defaultPageTimeout
+	}
+	return time.Duration(c.PageTimeout) * time.Second
+}
+
 type SmtpConfig struct {
 	Host *url.URL `json:"host"`
 	Port int `json:"port"`
@@ -32,5 +66,18 @@ func NewConfig(cfgFileName string) (*Config, error) {
 	}
 	cfg := &Config{}
 	json.Unmarshal(cfgBytes, cfg)
+
+	for _, dev := range cfg.Devices {
+		if dev.Id != "" {
+			continue
+		}
+		if dev.Backend == BackendSane {
+			dev.Id = dev.SaneDevice
+		} else if dev.URL != "" {
+			dev.Id = dev.URL
+		} else {
+			dev.Id = dev.hostname()
+		}
+	}
 	return cfg, nil
 }
\ No newline at end of file
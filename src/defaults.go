@@ -0,0 +1,150 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Image Formats the PDF is built from, the preferred one first.
+// see pagesToPDF
+var pdfImageFormats = []string{"image/jpeg", "image/png", "image/tiff"}
+
+// Image Formats preferred for black and white Pages: lossless
+// Pages are compressed with CCITT G4, JPEG noise spoils them
+var pdfBilevelFormats = []string{"image/png", "image/tiff", "image/jpeg"}
+
+// Formats the PDF is built from: PDFs of the Device are merged
+var pdfInputFormats = append([]string{"application/pdf"}, pdfImageFormats...)
+
+// ColorModes preferred per scan:Intent, the first supported wins
+var intentColorModes = map[string][]ColorMode{
+	"Document": {ColorModeGray, ColorModeColor, ColorModeBw},
+	"TextAndGraphic": {ColorModeColor, ColorModeGray},
+	"Photo": {ColorModeColor, ColorModeGray},
+	"Preview": {ColorModeColor, ColorModeGray},
+	"": {ColorModeColor, ColorModeGray, ColorModeBw},
+}
+
+// Resolutions in DPI preferred per scan:Intent
+var intentResolutions = map[string]int{
+	"Document": 200,
+	"TextAndGraphic": 300,
+	"Photo": 300,
+	"Preview": 100,
+}
+
+// DefaultSettings builds valid ScanSettings for the InputSource,
+// the first InputSource if empty. ColorMode and Resolution are
+// chosen by the scan:Intent, which is passed to the Device as
+// well. The Region covers the whole Area. A DocumentFormat error
+// is returned if the Device delivers none of the pdfInputFormats.
+// Devices without Capabilities get the Defaults of scanbridge.
+func (sd *ScanDevice) DefaultSettings(source string, intent string) (*ScanSettingsDto, error) {
+
+	if source == "" && len(sd.Is) > 0 {
+		source = sd.Is[0]
+	}
+	if len(sd.Is) > 0 && !slices.Contains(sd.Is, source) {
+		var errs ValidationErrors
+		errs.add("InputSource", "unsupported InputSource %s", source)
+		return nil, errs
+	}
+
+	dto := &ScanSettingsDto{
+		Version: sd.Version,
+		InputSource: source,
+		DocumentFormat: "image/" + scanFormat,
+		ColorMode: ColorModeColor,
+		Duplex: source == "adf-duplex",
+		Intent: intent,
+	}
+
+	dpi, ok := intentResolutions[intent]
+	if !ok {
+		dpi = scanResolution
+	}
+	dto.XResolution, dto.YResolution = dpi, dpi
+
+	colorModes, formats := sd.Cs, sd.documentFormats(dto.sourceKey())
+	caps := sd.Sources[dto.sourceKey()]
+	if caps != nil {
+		colorModes = caps.ColorModes
+		dto.XResolution, dto.YResolution = caps.closestResolution(dpi)
+		dto.Width, dto.Height = caps.MaxWidth, caps.MaxHeight
+	} else {
+		dto.Width, dto.Height, _ = scanRegionFor(defaultPaperSize, 0, 0, nil)
+	}
+
+	if mode := firstSupported(intentColorModes[intent], colorModes); mode != "" {
+		dto.ColorMode = mode
+	} else if len(colorModes) > 0 {
+		dto.ColorMode = colorModes[0]
+	}
+	// Devices producing PDF are preferred, scanbridge merges them
+	if sd.isPdfSupported(dto.sourceKey()) {
+		dto.DocumentFormat = "application/pdf"
+	} else if format := imageFormat(dto.ColorMode, formats); format != "" {
+		dto.DocumentFormat = format
+	} else if len(formats) > 0 {
+		var errs ValidationErrors
+		errs.add("DocumentFormat", "unsupported DocumentFormats %s, PDFs are built from: %s", strings.Join(formats, ","), strings.Join(pdfInputFormats, ","))
+		return nil, errs
+	}
+	return dto, nil
+}
+
+// imageFormat returns the preferred of the supported Image Formats
+// for the ColorMode, empty if none is supported
+func imageFormat(mode ColorMode, supported []string) string {
+	if mode == ColorModeBw {
+		return firstSupported(pdfBilevelFormats, supported)
+	}
+	return firstSupported(pdfImageFormats, supported)
+}
+
+// closestResolution returns the supported Resolution closest to dpi
+func (c *InputSourceCaps) closestResolution(dpi int) (x int, y int) {
+	x, y = dpi, dpi
+	best := -1
+	for _, res := range c.Resolutions {
+		if d := abs(res.XResolution-dpi) + abs(res.YResolution-dpi); best < 0 || d < best {
+			x, y, best = res.XResolution, res.YResolution, d
+		}
+	}
+	if r := c.ResolutionRange; r != nil {
+		rx, ry := r.XResolutionRange.closest(dpi), r.YResolutionRange.closest(dpi)
+		if d := abs(rx-dpi) + abs(ry-dpi); best < 0 || d < best {
+			x, y = rx, ry
+		}
+	}
+	return x, y
+}
+
+// closest returns the Value of the Axis closest to v
+func (axis ResolutionAxis) closest(v int) int {
+	v = min(max(v, axis.Min), axis.Max)
+	if axis.Step > 0 {
+		steps := (v - axis.Min + axis.Step/2) / axis.Step
+		v = min(axis.Min+steps*axis.Step, axis.Max)
+	}
+	return v
+}
+
+// firstSupported returns the first preferred Value contained in
+// supported, the zero Value if none is
+func firstSupported[T comparable](preferred []T, supported []T) T {
+	var zero T
+	for _, v := range preferred {
+		if slices.Contains(supported, v) {
+			return v
+		}
+	}
+	return zero
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/joho/godotenv"
+)
+
+const (
+	// pause between two mDNS browse rounds
+	browseInterval = 30 * time.Second
+	// duration of a single browse round
+	browseDuration = 4 * time.Second
+	// discovered Devices not seen for deviceTTL are dropped
+	deviceTTL = 3 * browseInterval
+	// timeout for fetching the ScannerCapabilities
+	capabilitiesTimeout = 5 * time.Second
+)
+
+// DeviceRegistry keeps track of all known Devices: the static
+// Devices of the Config and, if autodiscovery is enabled, the
+// Devices announced via mDNS (_uscan and _uscans). Discovery runs
+// in the Background, see Run, so the Registry answers instantly.
+type DeviceRegistry struct {
+	config *Config
+	// pins the Certificates of Devices discovered via _uscans
+	trustStore *TrustStore
+	mu sync.RWMutex
+	// discovered Devices by Id
+	discovered map[string]*registryEntry
+	// Scanners by Device Id, they keep the State of running Jobs
+	scanners map[string]Scanner
+}
+
+type registryEntry struct {
+	device *ScanDevice
+	lastSeen time.Time
+	// whether the Device carries its full ScannerCapabilities
+	// or only the TXT-Record Data
+	enriched bool
+}
+
+// mDNS Services of eSCL and the Scheme they are served with
+var esclServices = map[string]string{
+	"_uscan._tcp": "http",
+	"_uscans._tcp": "https",
+}
+
+// Run browses for eSCL-Devices until ctx is done.
+// It returns immediately if autodiscovery is disabled.
+func (dr *DeviceRegistry) Run(ctx context.Context) {
+	if dr.config.IsAutodiscovery == false {
+		return
+	}
+	for {
+		ifaces, err := multicastInterfaces()
+		if err != nil {
+			log.Printf("Err: %s", err)
+		}
+		var wg sync.WaitGroup
+		for service, scheme := range esclServices {
+			for _, iface := range ifaces {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := dr.browse(ctx, service, scheme, iface); err != nil {
+						log.Printf("Err: mDNS browse of %s on %s failed: %s", service, iface.Name, err)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+		dr.expire()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(browseInterval):
+		}
+	}
+}
+
+// Devices returns the static Devices followed by the discovered
+// ones. Discovered Devices matching a static one are omitted.
+func (dr *DeviceRegistry) Devices() []*ScanDevice {
+	devices := slices.Clone(dr.config.Devices)
+
+	dr.mu.RLock()
+	discovered := make([]*ScanDevice, 0, len(dr.discovered))
+	for _, entry := range dr.discovered {
+		if !slices.ContainsFunc(devices, entry.device.sameDevice) {
+			discovered = append(discovered, entry.device)
+		}
+	}
+	dr.mu.RUnlock()
+
+	slices.SortFunc(discovered, func(a, b *ScanDevice) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return append(devices, discovered...)
+}
+
+// Find returns the Device with the given Id. The first
+// Device is returned if no Id is given.
+func (dr *DeviceRegistry) Find(id string) (*ScanDevice, error) {
+	for _, dev := range dr.Devices() {
+		if id == "" || dev.Id == id {
+			return dev, nil
+		}
+	}
+	return nil, fmt.Errorf("Device %q not found", id)
+}
+
+// Scanner returns the Scanner of the Device. It is created once
+// per Device, so all Requests share the Jobs running on it.
+// Rediscovered Devices get a new Scanner.
+func (dr *DeviceRegistry) Scanner(device *ScanDevice) (Scanner, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if scanner, ok := dr.scanners[device.Id]; ok && scanner.Capabilities() == device {
+		return scanner, nil
+	}
+	scanner, err := NewScanner(device, dr.config)
+	if err != nil {
+		return nil, err
+	}
+	dr.scanners[device.Id] = scanner
+	return scanner, nil
+}
+
+// multicastInterfaces returns the Interfaces mDNS is browsed on
+func multicastInterfaces() ([]net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ifaces, func(iface net.Interface) bool {
+		return iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0
+	}), nil
+}
+
+// browse runs a single mDNS browse round for the Service on the
+// Interface. Browsing per Interface tells the Zone of link-local
+// IPv6 Addresses, which zeroconf does not report.
+func (dr *DeviceRegistry) browse(ctx context.Context, service string, scheme string, iface net.Interface) error {
+
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces([]net.Interface{iface}))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, browseDuration)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	done := make(chan struct{})
+	go func(results <-chan *zeroconf.ServiceEntry) {
+		defer close(done)
+		for entry := range results {
+			device, err := newDiscoveredScanDevice(entry, scheme, iface.Name)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if dr.touch(device) {
+				continue
+			}
+			if err := device.initClient(dr.trustStore); err != nil {
+				log.Println(err)
+				continue
+			}
+			device, enriched := dr.enrich(device)
+			dr.seen(device, enriched)
+		}
+	}(entries)
+
+	if err := resolver.Browse(ctx, service, "local", entries); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	// the resolver closes entries once it stopped
+	<-done
+	return nil
+}
+
+// seen adds or replaces a discovered Device
+func (dr *DeviceRegistry) seen(device *ScanDevice, enriched bool) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if _, known := dr.discovered[device.Id]; !known {
+		log.Println("discovered device", device.Id, device.Ty)
+	}
+	dr.discovered[device.Id] = &registryEntry{device: device, lastSeen: time.Now(), enriched: enriched}
+}
+
+// touch refreshes the lastSeen of an enriched Device. It reports
+// false if the Device is unknown, lacks its Capabilities or was
+// announced at another URL. Devices announcing both _uscan and
+// _uscans stick with HTTPS.
+func (dr *DeviceRegistry) touch(device *ScanDevice) bool {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	entry, known := dr.discovered[device.Id]
+	if !known || !entry.enriched {
+		return false
+	}
+	if entry.device.URL != device.URL && !(entry.device.isTls() && !device.isTls()) {
+		return false
+	}
+	entry.lastSeen = time.Now()
+	return true
+}
+
+// enrich queries the ScannerCapabilities of a discovered Device.
+// The TXT-Record Data is returned if that fails.
+func (dr *DeviceRegistry) enrich(txt *ScanDevice) (*ScanDevice, bool) {
+	client := &http.Client{Transport: txt.httpClient().Transport, Timeout: capabilitiesTimeout}
+	caps, err := NewScanDevice(client, txt.URL)
+	if err != nil {
+		log.Printf("Err: cant fetch capabilities of %s, using TXT record: %s", txt.Id, err)
+		return txt, false
+	}
+	return mergeScanDevice(txt, caps), true
+}
+
+// mergeScanDevice combines the Capabilities with the TXT-Record
+// Data of a Device. The Capabilities win, except for the Id
+// which has to stay stable across browse rounds.
+func mergeScanDevice(txt *ScanDevice, caps *ScanDevice) *ScanDevice {
+	merged := *caps
+	merged.Id = txt.Id
+	merged.AddrIPv4 = txt.AddrIPv4
+	merged.Host = txt.Host
+	merged.URL = txt.URL
+	merged.Tls = txt.Tls
+	// the enrich client is meant for the Capabilities only
+	merged.client = txt.client
+	if merged.Ty == "" {
+		merged.Ty = txt.Ty
+	}
+	// the TXT representation already points to the Device IP
+	if txt.Representation != "" {
+		merged.Representation = txt.Representation
+	}
+	if len(merged.Cs) == 0 {
+		merged.Cs = txt.Cs
+	}
+	if len(merged.Is) == 0 {
+		merged.Is = txt.Is
+	}
+	return &merged
+}
+
+// expire drops Devices not seen for deviceTTL
+func (dr *DeviceRegistry) expire() {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	for id, entry := range dr.discovered {
+		if time.Since(entry.lastSeen) > deviceTTL {
+			log.Println("lost device", id)
+			delete(dr.discovered, id)
+		}
+	}
+}
+
+// newDiscoveredScanDevice creates a ScanDevice from the
+// TXT-Record of the mDNS Announcement received on the Interface
+// zone
+func newDiscoveredScanDevice(se *zeroconf.ServiceEntry, scheme string, zone string) (*ScanDevice, error) {
+
+	// godotenv rejects Keys like mopria-certified-scan, the
+	// Values keep their hyphens, e.g. of the UUID or rs
+	lines := make([]string, len(se.Text))
+	for n, txt := range se.Text {
+		key, value, _ := strings.Cut(txt, "=")
+		lines[n] = strings.ReplaceAll(key, "-", "") + "=" + value
+	}
+	eSCLCapabilitiesMap, err := godotenv.Unmarshal(strings.Join(lines, "\n"))
+
+	if err != nil {
+		log.Println("godotenv:",err)
+		return nil, err
+	}
+
+	dev := &ScanDevice{
+		Backend: BackendEscl,
+	}
+	// IPv4 is preferred, then global IPv6, link-local IPv6 with
+	// the Zone it was announced in and finally the mDNS hostname
+	ipv6 := slices.IndexFunc(se.AddrIPv6, net.IP.IsGlobalUnicast)
+	linkLocal := slices.IndexFunc(se.AddrIPv6, net.IP.IsLinkLocalUnicast)
+	switch {
+	case len(se.AddrIPv4) > 0:
+		dev.AddrIPv4 = se.AddrIPv4[0]
+	case ipv6 >= 0:
+		dev.Host = se.AddrIPv6[ipv6].String()
+	case linkLocal >= 0 && zone != "":
+		dev.Host = se.AddrIPv6[linkLocal].String() + "%" + zone
+	case se.HostName != "":
+		dev.Host = strings.TrimSuffix(se.HostName, ".")
+	default:
+		return nil, fmt.Errorf("device %s announced without address", se.Instance)
+	}
+	dev.Id = dev.hostname()
+
+	// the eSCL-Interface lives at the announced Port below
+	// the "rs" Resource Path, e.g. rs=eSCL or rs=/scan/eSCL
+	rs := "eSCL"
+	if path, ok := eSCLCapabilitiesMap["rs"]; ok {
+		rs = strings.Trim(path, "/")
+	}
+	base := &url.URL{
+		Scheme: scheme,
+		Host: urlHost(dev.hostname(), se.Port),
+		Path: "/" + rs,
+	}
+	dev.URL = base.String()
+
+	if uuid, ok := eSCLCapabilitiesMap["UUID"]; ok {
+		dev.Id = uuid
+	}
+
+	if ty, ok := eSCLCapabilitiesMap["ty"]; ok {
+		dev.Ty = ty
+	}
+
+	if rep, ok := eSCLCapabilitiesMap["representation"]; ok {
+ 		url, err := url.Parse(rep)
+ 		if err == nil {
+ 			url.Host = urlHost(dev.hostname(), 0)
+			dev.Representation = url.String()
+		}
+	}
+
+	if cs, ok := eSCLCapabilitiesMap["cs"]; ok {
+		dev.Cs = normalizeColorModes(strings.Split(cs, ","))
+	}
+
+	if is, ok := eSCLCapabilitiesMap["is"]; ok {
+		dev.Is = strings.Split(is, ",")
+	}
+
+	if eSCLCapabilitiesMap["duplex"] == "T" && slices.Contains(dev.Is, "adf") {
+		dev.Is = append(dev.Is, "adf-duplex")
+	}
+
+	return  dev, nil
+}
+
+func NewDeviceRegistry(c *Config, ts *TrustStore) *DeviceRegistry {
+	return &DeviceRegistry{
+		config: c,
+		trustStore: ts,
+		discovered: map[string]*registryEntry{},
+		scanners: map[string]Scanner{},
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestRegistryMergesConfiguredAndDiscoveredDevices(t *testing.T) {
+	registry := NewDeviceRegistry(&Config{
+		Devices: []*ScanDevice{
+			{Id: "static", AddrIPv4: net.ParseIP("192.168.0.157")},
+		},
+	}, nil)
+
+	registry.seen(&ScanDevice{Id: "uuid-1", AddrIPv4: net.ParseIP("192.168.0.157")}, false)
+	registry.seen(&ScanDevice{Id: "uuid-2", AddrIPv4: net.ParseIP("192.168.0.158")}, false)
+
+	devices := registry.Devices()
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	if devices[0].Id != "static" || devices[1].Id != "uuid-2" {
+		t.Fatalf("unexpected devices %s, %s", devices[0].Id, devices[1].Id)
+	}
+
+	if dev, err := registry.Find(""); err != nil || dev.Id != "static" {
+		t.Fatalf("expected first device to be the static one")
+	}
+	if _, err := registry.Find("uuid-2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryExpiresDevices(t *testing.T) {
+	registry := NewDeviceRegistry(&Config{}, nil)
+	registry.seen(&ScanDevice{Id: "uuid-1"}, false)
+	registry.seen(&ScanDevice{Id: "uuid-2"}, true)
+	registry.discovered["uuid-1"].lastSeen = time.Now().Add(-deviceTTL - time.Second)
+
+	registry.expire()
+
+	if _, err := registry.Find("uuid-1"); err == nil {
+		t.Fatal("uuid-1 should have expired")
+	}
+	if _, err := registry.Find("uuid-2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeScanDevicePrefersCapabilities(t *testing.T) {
+	txt := &ScanDevice{
+		Id: "16a65700007c1000bb4930138b60d6ed",
+		Ty: "HP Color Laser MFP 179fnw",
+		Representation: "http://192.168.0.157/images/printer-icon128.png",
+		Cs: []ColorMode{ColorModeColor, ColorModeGray, ColorModeBw},
+		Is: []string{"platen", "adf"},
+	}
+	caps := &ScanDevice{
+		Id: "16a65700-007c-1000-bb49-30138b60d6ed",
+		Version: "2.63",
+		Representation: "http://HP30138B60D6ED.local./images/printer-icon128.png",
+		Cs: []ColorMode{ColorModeBw, ColorModeGray, ColorModeColor},
+		Pdl: []string{"application/pdf", "image/jpeg"},
+	}
+
+	merged := mergeScanDevice(txt, caps)
+
+	if merged.Id != txt.Id {
+		t.Fatalf("Id must stay stable, got %s", merged.Id)
+	}
+	if merged.Version != "2.63" || !merged.isPdfSupported("") {
+		t.Fatalf("capabilities missing: %+v", merged)
+	}
+	if merged.Ty != txt.Ty || merged.Representation != txt.Representation {
+		t.Fatalf("TXT data missing: %+v", merged)
+	}
+	if merged.Cs[0] != ColorModeBw || len(merged.Is) != 2 {
+		t.Fatalf("unexpected Cs/Is: %v %v", merged.Cs, merged.Is)
+	}
+}
+
+func TestDiscoveredDeviceHonorsPortAndResourcePath(t *testing.T) {
+	entry := zeroconf.NewServiceEntry("HP Color Laser MFP 179fnw", "_uscan._tcp", "local")
+	entry.Port = 8080
+	entry.AddrIPv4 = []net.IP{net.ParseIP("192.168.0.157")}
+	entry.Text = []string{
+		"ty=HP Color Laser MFP 179fnw",
+		"rs=/scan-api/eSCL",
+		"is=platen,adf",
+		"mopria-certified-scan=1.3",
+		"UUID=564e4333-4d32-3634-3259-3acdad1b1a5c",
+	}
+
+	dev, err := newDiscoveredScanDevice(entry, "http", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.URL != "http://192.168.0.157:8080/scan-api/eSCL" {
+		t.Fatalf("unexpected URL %s", dev.URL)
+	}
+	if dev.Id != "564e4333-4d32-3634-3259-3acdad1b1a5c" {
+		t.Fatalf("unexpected Id %s", dev.Id)
+	}
+}
+
+func TestDiscoveredDeviceWithoutIPv4(t *testing.T) {
+	entry := zeroconf.NewServiceEntry("HP Color Laser MFP 179fnw", "_uscan._tcp", "local")
+	entry.Port = 8080
+	entry.HostName = "HP30138B60D6ED.local."
+	entry.AddrIPv6 = []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::157")}
+
+	dev, err := newDiscoveredScanDevice(entry, "http", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.URL != "http://[2001:db8::157]:8080/eSCL" {
+		t.Fatalf("unexpected URL %s", dev.URL)
+	}
+
+	// link-local IPv6 is reached via the Interface it was announced on
+	entry.AddrIPv6 = []net.IP{net.ParseIP("fe80::1")}
+	dev, err = newDiscoveredScanDevice(entry, "http", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.URL != "http://[fe80::1%25eth0]:8080/eSCL" {
+		t.Fatalf("unexpected URL %s", dev.URL)
+	}
+
+	dev, err = newDiscoveredScanDevice(entry, "http", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.URL != "http://HP30138B60D6ED.local:8080/eSCL" {
+		t.Fatalf("unexpected URL %s", dev.URL)
+	}
+}
+
+func TestRegistrySharesScannerPerDevice(t *testing.T) {
+	dir := t.TempDir()
+	scanimage := filepath.Join(dir, "scanimage")
+	if err := os.WriteFile(scanimage, []byte("#!/bin/sh\nsleep 1\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	device := &ScanDevice{Id: "flatbed", Backend: BackendSane, SaneDevice: "test:0"}
+	registry := NewDeviceRegistry(&Config{Devices: []*ScanDevice{device}, Scanimage: scanimage}, nil)
+
+	scanner, err := registry.Scanner(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := scanner.StartJob(&ScanSettingsDto{InputSource: "Flatbed", ColorMode: ColorModeGray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Cancel()
+
+	// e.g. the Status Endpoint, which fetches the Scanner again
+	again, err := registry.Scanner(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := again.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != scanner || status.State != ScannerStateProcessing {
+		t.Fatalf("expected the running job to be reported, got %s", status.State)
+	}
+
+	job.Cancel()
+	if status, _ := again.Status(); status.State != ScannerStateIdle {
+		t.Fatalf("expected idle device after the job, got %s", status.State)
+	}
+}
@@ -1,107 +1,86 @@
 package main
 
 import (
-	"context"
-	"time"
 	"log"
 	"encoding/json"
 	"net/http"
-	"net/url"
-	"strings"
-	"github.com/grandcat/zeroconf"
-	"github.com/joho/godotenv"
 )
 
 type DevicesController struct {
 	config *Config
-	Devices []*ScanDevice `json:"devices"`
+	registry *DeviceRegistry
 }
 
+// ServeHTTP lists all Devices known to the DeviceRegistry
 func (dc *DevicesController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-    var err error
-    var dev []*ScanDevice
-	dec := json.NewEncoder(w)
-    if dc.config.IsAutodiscovery == true {
-    	dev, err = dc.discover()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Err: %s", err)
-			dec.Encode(&Notification{Data: "cant fetch devices!", Title: "KO!"})
-			return
-		}
-    } else {
-    	dev = dc.config.Devices
-    }
-	dec.Encode(dev)
+	json.NewEncoder(w).Encode(dc.registry.Devices())
 }
 
-func (dc *DevicesController) discover() ([]*ScanDevice, error) {
-
-	resolver, err := zeroconf.NewResolver(nil)
+// ServeStatus reports the ScannerStatus of the Device
+// given by the {id} path value
+func (dc *DevicesController) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewEncoder(w)
+	device, err := dc.Find(r.PathValue("id"))
 	if err != nil {
-		return nil, err
+		w.WriteHeader(http.StatusNotFound)
+		dec.Encode(&Notification{Data: "Der Scanner wurde nicht gefunden.", Title: "KO!"})
+		return
 	}
-	
-	var buffer []*ScanDevice = []*ScanDevice{}
-	entries := make(chan *zeroconf.ServiceEntry)
-	go func(results <-chan *zeroconf.ServiceEntry) {
-		for entry := range results {
-			device, err := dc.newScanDevice(entry)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			buffer = append(buffer, device)
-		}
-	}(entries)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(4))
-	defer cancel()
-	err = resolver.Browse(ctx, "_uscan._tcp", "local", entries)
+	scanner, err := dc.Scanner(device)
 	if err != nil {
-		return nil, err
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Err: %s", err)
+		dec.Encode(&Notification{Data: "Der Scanner wird nicht unterstützt.", Title: "KO!"})
+		return
 	}
-
-	<-ctx.Done()
-
-	return buffer, nil
-}
-
-func (dc *DevicesController) newScanDevice(se *zeroconf.ServiceEntry) (*ScanDevice, error) {
-
-	eSCL := strings.Replace(strings.Join(se.Text, "\n"), "-", "", -1)
-	eSCLCapabilitiesMap, err := godotenv.Unmarshal(eSCL)
-
+	status, err := scanner.Status()
 	if err != nil {
-		log.Println("godotenv:",err)
-		return nil, err
+		w.WriteHeader(http.StatusBadGateway)
+		log.Printf("Err: %s", err)
+		dec.Encode(&Notification{Data: "Der Scanner ist nicht erreichbar.", Title: "KO!"})
+		return
 	}
+	dec.Encode(status)
+}
 
-	dev := &ScanDevice{AddrIPv4: se.AddrIPv4[0]}
-
-	if ty, ok := eSCLCapabilitiesMap["ty"]; ok {
-		dev.Ty = ty
-	}
+// deviceCapabilities is the Body of GET /api/devices/{id}/capabilities
+type deviceCapabilities struct {
+	Id string `json:"id"`
+	Name string `json:"name"`
+	InputSources []string `json:"input_sources"`
+	Sources map[string]*InputSourceCaps `json:"sources"`
+}
 
-	if rep, ok := eSCLCapabilitiesMap["representation"]; ok {
- 		url, err := url.Parse(rep)
- 		if err == nil {
- 			url.Host = se.AddrIPv4[0].String()
-			dev.Representation = url.String()
-		}
+// ServeCapabilities reports the Capabilities per InputSource of
+// the Device given by the {id} path value, so Clients offer only
+// valid Settings for the selected InputSource
+func (dc *DevicesController) ServeCapabilities(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewEncoder(w)
+	device, err := dc.Find(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		dec.Encode(&Notification{Data: "Der Scanner wurde nicht gefunden.", Title: "KO!"})
+		return
 	}
+	dec.Encode(&deviceCapabilities{
+		Id: device.Id,
+		Name: device.Ty,
+		InputSources: device.Is,
+		Sources: device.SourceCaps(),
+	})
+}
 
-	if cs, ok := eSCLCapabilitiesMap["cs"]; ok {
-		dev.Cs = strings.Split(cs, ",")
-	}
-	
-	if is, ok := eSCLCapabilitiesMap["is"]; ok {
-		dev.Is = strings.Split(is, ",")
-	}
+// Find returns the Device with the given Id. The first
+// Device is returned if no Id is given.
+func (dc *DevicesController) Find(id string) (*ScanDevice, error) {
+	return dc.registry.Find(id)
+}
 
-	return  dev, nil
+// Scanner returns the Scanner of the Device shared by all Requests
+func (dc *DevicesController) Scanner(device *ScanDevice) (Scanner, error) {
+	return dc.registry.Scanner(device)
 }
 
-func NewDevicesController(c *Config) *DevicesController {
-	return  &DevicesController{config: c}
+func NewDevicesController(c *Config, registry *DeviceRegistry) *DevicesController {
+	return  &DevicesController{config: c, registry: registry}
 }
\ No newline at end of file
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// imageDPI reads the Resolution stored in the pHYs Chunk of a
+// PNG or the JFIF Header of a JPEG. ok is false if the Image
+// carries none, e.g. only an Aspect Ratio.
+func imageDPI(file string) (x float64, y float64, ok bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	// the Resolution is stored in front of the Image Data
+	head := make([]byte, 64*1024)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return pngDPI(head[8:])
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		return jfifDPI(head[2:])
+	}
+	return 0, 0, false
+}
+
+// pngDPI searches the Chunks for pHYs, whose Unit 1 is the meter
+func pngDPI(chunks []byte) (float64, float64, bool) {
+	for len(chunks) >= 8 {
+		length := int(binary.BigEndian.Uint32(chunks))
+		typ := string(chunks[4:8])
+		if typ == "IDAT" || len(chunks) < 12+length {
+			break
+		}
+		data := chunks[8 : 8+length]
+		if typ == "pHYs" && length == 9 && data[8] == 1 {
+			x := float64(binary.BigEndian.Uint32(data[0:4])) * 0.0254
+			y := float64(binary.BigEndian.Uint32(data[4:8])) * 0.0254
+			return x, y, x > 0 && y > 0
+		}
+		chunks = chunks[12+length:]
+	}
+	return 0, 0, false
+}
+
+// jfifDPI reads the Density of the APP0 Segment. Units are
+// 1 for dots per inch and 2 for dots per cm.
+func jfifDPI(segments []byte) (float64, float64, bool) {
+	if len(segments) < 16 || segments[0] != 0xff || segments[1] != 0xe0 || !bytes.Equal(segments[4:9], []byte("JFIF\x00")) {
+		return 0, 0, false
+	}
+	x := float64(binary.BigEndian.Uint16(segments[12:14]))
+	y := float64(binary.BigEndian.Uint16(segments[14:16]))
+	switch segments[11] {
+	case 1:
+	case 2:
+		x, y = x*2.54, y*2.54
+	default:
+		return 0, 0, false
+	}
+	return x, y, x > 0 && y > 0
+}
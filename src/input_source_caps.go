@@ -0,0 +1,131 @@
+package main
+
+import (
+	"slices"
+)
+
+// InputSourceCaps are the normalized Capabilities of a single
+// InputSource of an eSCL-Device. Sizes and Margins are given
+// in 1/300 inch, Resolutions in DPI.
+type InputSourceCaps struct {
+	ColorModes []ColorMode `json:"color_modes"`
+	DocumentFormats []string `json:"document_formats"`
+	// discrete Resolutions
+	Resolutions []DiscreteResolution `json:"resolutions,omitempty"`
+	// Resolutions, if the Device supports a Range
+	ResolutionRange *ResolutionRange `json:"resolution_range,omitempty"`
+	MinWidth int `json:"min_width"`
+	MaxWidth int `json:"max_width"`
+	MinHeight int `json:"min_height"`
+	MaxHeight int `json:"max_height"`
+	MaxOpticalXResolution int `json:"max_optical_x_resolution,omitempty"`
+	MaxOpticalYResolution int `json:"max_optical_y_resolution,omitempty"`
+	// Margins the Device may not scan reliably
+	RiskyLeftMargin int `json:"risky_left_margin"`
+	RiskyRightMargin int `json:"risky_right_margin"`
+	RiskyTopMargin int `json:"risky_top_margin"`
+	RiskyBottomMargin int `json:"risky_bottom_margin"`
+	// scan:Intent values, e.g. Document or Photo
+	Intents []string `json:"intents,omitempty"`
+	// Edges the Device detects itself, see PaperSizeAuto
+	EdgeAutoDetection []string `json:"edge_auto_detection,omitempty"`
+}
+
+// inputCaps are the Elements shared by PlatenInputCaps
+// and AdfSimplexInputCaps
+type inputCaps struct {
+	MinWidth, MaxWidth, MinHeight, MaxHeight int
+	SettingProfiles SettingProfiles
+	SupportedResolutions SupportedResolutions
+	MaxOpticalXResolution, MaxOpticalYResolution int
+	RiskyLeftMargin, RiskyRightMargin, RiskyTopMargin, RiskyBottomMargin int
+	SupportedIntents []string
+	SupportedEdges []string
+}
+
+// normalize merges the SettingProfiles of the InputSource.
+// Devices listing none for the InputSource share the
+// top-level SettingProfiles.
+func (ic inputCaps) normalize(shared SettingProfiles) *InputSourceCaps {
+	caps := &InputSourceCaps{
+		ColorModes: []ColorMode{},
+		DocumentFormats: []string{},
+		MinWidth: ic.MinWidth,
+		MaxWidth: ic.MaxWidth,
+		MinHeight: ic.MinHeight,
+		MaxHeight: ic.MaxHeight,
+		MaxOpticalXResolution: ic.MaxOpticalXResolution,
+		MaxOpticalYResolution: ic.MaxOpticalYResolution,
+		RiskyLeftMargin: ic.RiskyLeftMargin,
+		RiskyRightMargin: ic.RiskyRightMargin,
+		RiskyTopMargin: ic.RiskyTopMargin,
+		RiskyBottomMargin: ic.RiskyBottomMargin,
+		Intents: ic.SupportedIntents,
+		EdgeAutoDetection: ic.SupportedEdges,
+	}
+	profiles := ic.SettingProfiles.Profiles
+	if len(profiles) == 0 {
+		profiles = shared.Profiles
+	}
+	resolutions := []SupportedResolutions{ic.SupportedResolutions}
+	for _, profile := range profiles {
+		caps.ColorModes = appendMissing(caps.ColorModes, normalizeColorModes(profile.ColorModes)...)
+		caps.DocumentFormats = appendMissing(caps.DocumentFormats, profile.DocumentFormats.DocumentFormat...)
+		resolutions = append(resolutions, profile.SupportedResolutions)
+	}
+	for _, supported := range resolutions {
+		caps.Resolutions = appendMissing(caps.Resolutions, supported.DiscreteResolutions...)
+		if caps.ResolutionRange == nil && supported.ResolutionRange != nil {
+			caps.ResolutionRange = supported.ResolutionRange
+		}
+	}
+	return caps
+}
+
+func (c *PlatenInputCaps) sourceCaps(shared SettingProfiles) *InputSourceCaps {
+	return inputCaps{
+		c.MinWidth, c.MaxWidth, c.MinHeight, c.MaxHeight,
+		c.SettingProfiles,
+		c.SupportedResolutions,
+		c.MaxOpticalXResolution, c.MaxOpticalYResolution,
+		c.RiskyLeftMargin, c.RiskyRightMargin, c.RiskyTopMargin, c.RiskyBottomMargin,
+		c.SupportedIntents,
+		nil,
+	}.normalize(shared)
+}
+
+func (c *AdfSimplexInputCaps) sourceCaps(shared SettingProfiles) *InputSourceCaps {
+	return inputCaps{
+		c.MinWidth, c.MaxWidth, c.MinHeight, c.MaxHeight,
+		c.SettingProfiles,
+		SupportedResolutions{},
+		c.MaxOpticalXResolution, c.MaxOpticalYResolution,
+		c.RiskyLeftMargin, c.RiskyRightMargin, c.RiskyTopMargin, c.RiskyBottomMargin,
+		c.SupportedIntents,
+		c.EdgeAutoDetection.SupportedEdges,
+	}.normalize(shared)
+}
+
+// SourceCaps returns the Capabilities of all InputSources. Devices
+// without ScannerCapabilities, e.g. configured or SANE ones, offer
+// their ColorModes and Formats for every InputSource.
+func (sd *ScanDevice) SourceCaps() map[string]*InputSourceCaps {
+	if len(sd.Sources) > 0 {
+		return sd.Sources
+	}
+	sources := map[string]*InputSourceCaps{}
+	for _, is := range sd.Is {
+		sources[is] = &InputSourceCaps{ColorModes: sd.Cs, DocumentFormats: sd.Pdl}
+	}
+	return sources
+}
+
+// appendMissing appends the values not yet contained in s
+func appendMissing[T comparable](s []T, values ...T) []T {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobState is the lifecycle State of a Job
+type JobState string
+
+const (
+	JobStateQueued     JobState = "queued"
+	JobStateScanning   JobState = "scanning"
+	// manual Duplex: the front Sides are scanned, the User
+	// has to flip the Stack and continue the Job
+	JobStateWaitingForFlip JobState = "waiting_for_flip"
+	JobStateProcessing JobState = "processing"
+	JobStateDelivering JobState = "delivering"
+	JobStateDone       JobState = "done"
+	JobStateFailed     JobState = "failed"
+	JobStateCancelled  JobState = "cancelled"
+)
+
+// finished Jobs are forgotten after jobRetention
+const jobRetention = 24 * time.Hour
+
+// how long a Job waits for a Device busy with foreign Jobs,
+// e.g. a Copy started at the Device itself
+const deviceBusyTimeout = 10 * time.Minute
+
+// how long a manual Duplex Job waits for the Stack to be flipped
+const flipTimeout = 10 * time.Minute
+
+// JobStatus is the public, JSON-encodable State of a Job
+type JobStatus struct {
+	Id uuid.UUID `json:"id"`
+	Device string `json:"device"`
+	State JobState `json:"state"`
+	// number of Jobs ahead in the Queue of the Device
+	QueuePosition int `json:"queue_position"`
+	// number of Pages scanned so far
+	Pages int `json:"pages"`
+	// scan the front Sides, then the back Sides of the flipped Stack
+	ManualDuplex bool `json:"manual_duplex"`
+	Error string `json:"error,omitempty"`
+	// download URL of the PDF once the Job is done
+	URL string `json:"url,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Job is a Scan running in the Background. Its Id is
+// also the Name of the resulting PDF.
+type Job struct {
+	mu sync.Mutex
+	status JobStatus
+	scanner Scanner
+	dto *ScanSettingsDto
+	ctx context.Context
+	cancel context.CancelFunc
+	// signalled by Continue once the Stack is flipped
+	flipped chan struct{}
+	subscribers []chan JobEvent
+}
+
+// JobEvent is streamed to the Subscribers of a Job
+type JobEvent struct {
+	// "state", "queue" or "page"
+	Type string `json:"type"`
+	Status JobStatus `json:"status"`
+	// data URI of a JPEG thumbnail of the scanned Page
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// Subscribe returns a Channel receiving all further JobEvents.
+// It is closed once the Job finished or unsubscribe is called.
+func (job *Job) Subscribe() (events <-chan JobEvent, unsubscribe func()) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	ch := make(chan JobEvent, 32)
+	switch job.status.State {
+	case JobStateDone, JobStateFailed, JobStateCancelled:
+		close(ch)
+		return ch, func() {}
+	}
+	job.subscribers = append(job.subscribers, ch)
+	return ch, func() {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if i := slices.Index(job.subscribers, ch); i >= 0 {
+			job.subscribers = slices.Delete(job.subscribers, i, i+1)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the Event to all Subscribers, slow Subscribers
+// miss Events. The caller must hold job.mu.
+func (job *Job) publish(eventType string, thumbnail string) {
+	event := JobEvent{Type: eventType, Status: job.status, Thumbnail: thumbnail}
+	for _, ch := range job.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	switch job.status.State {
+	case JobStateDone, JobStateFailed, JobStateCancelled:
+		for _, ch := range job.subscribers {
+			close(ch)
+		}
+		job.subscribers = nil
+	}
+}
+
+// Status returns a Snapshot of the JobStatus
+func (job *Job) Status() JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.status
+}
+
+// IsFinished reports whether the Job reached a final State
+func (job *Job) IsFinished() bool {
+	switch job.Status().State {
+	case JobStateDone, JobStateFailed, JobStateCancelled:
+		return true
+	}
+	return false
+}
+
+func (job *Job) setState(state JobState) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.State = state
+	job.status.Updated = time.Now()
+	job.publish("state", "")
+}
+
+// addPage counts a scanned Page
+func (job *Job) addPage(page *ScanPage) {
+	thumb, err := thumbnail(page)
+	if err != nil && *debug == true {
+		log.Println("DEBUG:no thumbnail:", err)
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Pages++
+	job.status.Updated = time.Now()
+	job.publish("page", thumb)
+}
+
+// waitForFlip asks the User to flip the Stack and blocks
+// until the Job is continued. The Job keeps its Slot in the Queue
+// of the Device meanwhile, other Jobs would feed the Stack waiting
+// in the ADF. They wait for flipTimeout at most.
+func (job *Job) waitForFlip(ctx context.Context) error {
+	job.setState(JobStateWaitingForFlip)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-job.flipped:
+		return nil
+	case <-time.After(flipTimeout):
+		return fmt.Errorf("stack not flipped within %s", flipTimeout)
+	}
+}
+
+// Continue resumes a Job waiting for the Stack to be flipped
+func (job *Job) Continue() error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status.State != JobStateWaitingForFlip {
+		return fmt.Errorf("Job %s is not waiting for the stack to be flipped", job.status.Id)
+	}
+	select {
+	case job.flipped <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (job *Job) fail(err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.State = JobStateFailed
+	job.status.Error = err.Error()
+	job.status.Updated = time.Now()
+	job.publish("state", "")
+}
+
+// JobManager runs Jobs in the Background and
+// keeps track of their State. Jobs of the same Device
+// are serialized, see work.
+type JobManager struct {
+	mu sync.Mutex
+	jobs map[uuid.UUID]*Job
+	// Jobs per Device Id, the first one is running
+	queues map[string][]*Job
+	// Directory the PDFs are written to
+	storageDir string
+}
+
+// Submit enqueues a new Job and returns immediately. A manual
+// Duplex Job pauses after the front Sides, see Job.Continue.
+func (jm *JobManager) Submit(scanner Scanner, dto *ScanSettingsDto, manualDuplex bool) *Job {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job := &Job{
+		status: JobStatus{
+			Id: uuid.New(),
+			Device: scanner.Capabilities().Id,
+			State: JobStateQueued,
+			ManualDuplex: manualDuplex,
+			Created: now,
+			Updated: now,
+		},
+		scanner: scanner,
+		dto: dto,
+		ctx: ctx,
+		cancel: cancel,
+		flipped: make(chan struct{}, 1),
+	}
+
+	device := job.status.Device
+
+	jm.mu.Lock()
+	jm.prune()
+	jm.jobs[job.status.Id] = job
+	jm.queues[device] = append(jm.queues[device], job)
+	jm.updatePositions(device)
+	if len(jm.queues[device]) == 1 {
+		go jm.work(device)
+	}
+	jm.mu.Unlock()
+
+	return job
+}
+
+// Get returns the Job with the given Id
+func (jm *JobManager) Get(id uuid.UUID) (*Job, bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	job, ok := jm.jobs[id]
+	return job, ok
+}
+
+// Cancel aborts the Job with the given Id. Once its Pages are
+// scanned, the Job is processed and delivered to the end, or a
+// mailed PDF would be removed.
+func (jm *JobManager) Cancel(id uuid.UUID) error {
+	job, ok := jm.Get(id)
+	if !ok {
+		return fmt.Errorf("Job %s not found", id)
+	}
+	// under job.mu, so scan sees the cancelled ctx once it
+	// entered JobStateProcessing
+	job.mu.Lock()
+	switch job.status.State {
+	case JobStateQueued, JobStateScanning, JobStateWaitingForFlip:
+		job.cancel()
+	case JobStateDone, JobStateFailed, JobStateCancelled:
+		job.mu.Unlock()
+		return fmt.Errorf("Job %s already finished", id)
+	default:
+		job.mu.Unlock()
+		return fmt.Errorf("Job %s is %s and can no longer be cancelled", id, job.status.State)
+	}
+	job.mu.Unlock()
+
+	// Jobs still waiting are dequeued right away
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	device := job.status.Device
+	if i := slices.Index(jm.queues[device], job); i > 0 {
+		jm.queues[device] = slices.Delete(jm.queues[device], i, i+1)
+		jm.updatePositions(device)
+		job.setState(JobStateCancelled)
+	}
+	return nil
+}
+
+// work runs the queued Jobs of the Device one after
+// another until its Queue is empty
+func (jm *JobManager) work(device string) {
+	for {
+		jm.mu.Lock()
+		job := jm.queues[device][0]
+		jm.mu.Unlock()
+
+		jm.run(job)
+
+		jm.mu.Lock()
+		jm.queues[device] = jm.queues[device][1:]
+		if len(jm.queues[device]) == 0 {
+			delete(jm.queues, device)
+			jm.mu.Unlock()
+			return
+		}
+		jm.updatePositions(device)
+		jm.mu.Unlock()
+	}
+}
+
+// updatePositions renumbers the Queue of the Device,
+// the caller must hold jm.mu
+func (jm *JobManager) updatePositions(device string) {
+	for i, job := range jm.queues[device] {
+		job.mu.Lock()
+		if job.status.QueuePosition != i {
+			job.status.QueuePosition = i
+			job.publish("queue", "")
+		}
+		job.mu.Unlock()
+	}
+}
+
+func (jm *JobManager) run(job *Job) {
+	defer job.cancel()
+
+	err := waitForDevice(job.ctx, job.scanner)
+	if err == nil {
+		err = scan(job.ctx, job, jm.storageDir)
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.setState(JobStateCancelled)
+	case err != nil:
+		log.Printf("Err: job %s: %s", job.status.Id, err)
+		job.fail(err)
+	default:
+		job.mu.Lock()
+		job.status.URL = fmt.Sprintf("/api/download/%s", job.status.Id)
+		job.mu.Unlock()
+		job.setState(JobStateDone)
+	}
+}
+
+// waitForDevice blocks while the Device is busy. A Job cancelled
+// meanwhile must not start a Scan on the Device.
+func waitForDevice(ctx context.Context, scanner Scanner) error {
+	deadline := time.Now().Add(deviceBusyTimeout)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		status, err := scanner.Status()
+		// unreachable Devices are reported by the Scan itself
+		if err != nil || !status.IsBusy() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Device %s busy for too long", scanner.Capabilities().Id)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// prune forgets finished Jobs older than jobRetention,
+// the caller must hold jm.mu
+func (jm *JobManager) prune() {
+	for id, job := range jm.jobs {
+		if job.IsFinished() && time.Since(job.Status().Updated) > jobRetention {
+			delete(jm.jobs, id)
+		}
+	}
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs: map[uuid.UUID]*Job{},
+		queues: map[string][]*Job{},
+		storageDir: pdfStorageDir,
+	}
+}
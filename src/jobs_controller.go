@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// JobsController serves the /api/jobs Endpoints
+type JobsController struct {
+	config *Config
+	devices *DevicesController
+	manager *JobManager
+}
+
+// jobRequest is the Body of POST /api/jobs. All Settings are
+// optional, see ScanDevice.DefaultSettings for their Defaults.
+type jobRequest struct {
+	// Id of the Device, the first Device if empty
+	Device string `json:"device"`
+	Source string `json:"source"`
+	// scan:Intent the Defaults are chosen for, e.g. Document or Photo
+	Intent string `json:"intent"`
+	// "color", "gray" or "bw", Backend specific Names are accepted
+	Mode ColorMode `json:"mode"`
+	// Resolution in DPI
+	Resolution int `json:"resolution"`
+	// MIME-Type scanned by the Device
+	Format string `json:"format"`
+	// scan both Sides of the adf Source
+	Duplex bool `json:"duplex"`
+	// scan both Sides of a simplex ADF by flipping the Stack
+	ManualDuplex bool `json:"manual_duplex"`
+	// Name of a PaperSize, "custom" or "auto", the max Area if empty
+	PaperSize string `json:"paper_size"`
+	// Size of the "custom" PaperSize
+	WidthMm float64 `json:"width_mm"`
+	HeightMm float64 `json:"height_mm"`
+	// tesseract Language for a searchable PDF, e.g. deu or deu+eng,
+	// no OCR if empty
+	OcrLanguage string `json:"ocr_language"`
+}
+
+// applyOverrides replaces the Defaults by the Settings of the jobRequest
+func applyOverrides(dto *ScanSettingsDto, req *jobRequest, device *ScanDevice) error {
+	if req.Mode != "" {
+		if !slices.Contains([]ColorMode{ColorModeColor, ColorModeGray, ColorModeBw}, req.Mode) {
+			var errs ValidationErrors
+			errs.add("ColorMode", "unknown ColorMode %s, known are: color,gray,bw", req.Mode)
+			return errs
+		}
+		dto.ColorMode = req.Mode
+	}
+	// the Capabilities depend on the Duplex flag
+	if req.Duplex {
+		dto.Duplex = true
+	}
+	if req.Resolution != 0 {
+		dto.XResolution, dto.YResolution = req.Resolution, req.Resolution
+		if caps := device.Sources[dto.sourceKey()]; caps != nil {
+			dto.XResolution, dto.YResolution = caps.closestResolution(req.Resolution)
+		}
+	}
+	// the default Image Format depends on the ColorMode
+	if req.Mode != "" && req.Format == "" && dto.DocumentFormat != "application/pdf" {
+		if format := imageFormat(dto.ColorMode, device.documentFormats(dto.sourceKey())); format != "" {
+			dto.DocumentFormat = format
+		}
+	}
+	if req.Format != "" {
+		if !slices.Contains(pdfInputFormats, req.Format) {
+			var errs ValidationErrors
+			errs.add("DocumentFormat", "unsupported DocumentFormat %s, PDFs are built from: %s", req.Format, strings.Join(pdfInputFormats, ","))
+			return errs
+		}
+		dto.DocumentFormat = req.Format
+	}
+	if req.PaperSize != "" {
+		var err error
+		dto.Width, dto.Height, err = scanRegionFor(req.PaperSize, req.WidthMm, req.HeightMm, device.Sources[dto.sourceKey()])
+		if err != nil {
+			return err
+		}
+	}
+	if req.ManualDuplex {
+		var errs ValidationErrors
+		if dto.isDuplex() {
+			errs.add("ManualDuplex", "ManualDuplex cant be combined with Duplex, the Device scans both Sides itself")
+		} else if !isAdfSource(dto.InputSource) {
+			errs.add("ManualDuplex", "ManualDuplex requires the adf InputSource, got %s", dto.InputSource)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+	return nil
+}
+
+// applyOcr enables OCR in the Language of the jobRequest. The
+// Device recognizes the Text if it can, otherwise tesseract does,
+// which requires Images instead of PDFs.
+func applyOcr(dto *ScanSettingsDto, req *jobRequest, device *ScanDevice, tesseract string) error {
+	if req.OcrLanguage == "" {
+		return nil
+	}
+	var errs ValidationErrors
+	if !ocrLanguagePattern.MatchString(req.OcrLanguage) {
+		errs.add("OcrLanguage", "invalid OCR language %s, expected e.g. deu or deu+eng", req.OcrLanguage)
+		return errs
+	}
+	dto.OcrLanguage = req.OcrLanguage
+	if device.ocrsOnDevice(dto) {
+		return nil
+	}
+	if tesseract == "" {
+		errs.add("OcrLanguage", "OCR is neither supported by the Device nor is tesseract installed")
+		return errs
+	}
+	if dto.DocumentFormat != "application/pdf" {
+		return nil
+	}
+
+	format := imageFormat(dto.ColorMode, device.documentFormats(dto.sourceKey()))
+	if req.Format != "" || format == "" {
+		errs.add("DocumentFormat", "OCR by tesseract requires an image format, the Device delivers %s", dto.DocumentFormat)
+		return errs
+	}
+	dto.DocumentFormat = format
+	return nil
+}
+
+// validationResponse reports the invalid Fields of a jobRequest
+type validationResponse struct {
+	Notification
+	Errors ValidationErrors `json:"errors"`
+}
+
+// Create submits a new Job and returns its JobStatus
+// without waiting for the Scan
+func (jc *JobsController) Create(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewEncoder(w)
+	title := "Scan kann nicht ausgeführt werden!"
+
+	req := &jobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		dec.Encode(&Notification{Data: "Ungültige Anfrage.", Title: title})
+		return
+	}
+
+	device, err := jc.devices.Find(req.Device)
+	if err != nil {
+		log.Printf("Err: %s", err)
+		w.WriteHeader(http.StatusNotFound)
+		dec.Encode(&Notification{Data: "Der Scanner wurde nicht gefunden.", Title: title})
+		return
+	}
+
+	scanner, err := jc.devices.Scanner(device)
+	if err != nil {
+		log.Printf("Err: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		dec.Encode(&Notification{Data: "Der Scanner wird nicht unterstützt.", Title: title})
+		return
+	}
+
+	dto, err := device.DefaultSettings(req.Source, req.Intent)
+	if err == nil {
+		err = applyOverrides(dto, req, device)
+	}
+	if err == nil {
+		err = applyOcr(dto, req, device, jc.config.Tesseract)
+	}
+	if err == nil {
+		err = scanner.Validate(dto)
+	}
+	if err != nil {
+		log.Printf("Err: %s", err)
+		var errs ValidationErrors
+		errors.As(err, &errs)
+		w.WriteHeader(http.StatusBadRequest)
+		dec.Encode(&validationResponse{
+			Notification: Notification{Data: "Der Scanner unterstützt diese Einstellungen nicht.", Title: title},
+			Errors: errs,
+		})
+		return
+	}
+
+	status, err := scanner.Status()
+	if err != nil {
+		log.Printf("Err: %s", err)
+	} else if notification := statusNotification(status, dto.InputSource); notification != nil {
+		w.WriteHeader(http.StatusConflict)
+		dec.Encode(notification)
+		return
+	}
+
+	job := jc.manager.Submit(scanner, dto, req.ManualDuplex)
+
+	w.WriteHeader(http.StatusAccepted)
+	dec.Encode(job.Status())
+}
+
+// Show reports the JobStatus of the Job given by the {id} path value
+func (jc *JobsController) Show(w http.ResponseWriter, r *http.Request) {
+	job, ok := jc.job(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+// Events streams the JobEvents of the Job given by the {id}
+// path value as Server-Sent Events until the Job finished
+func (jc *JobsController) Events(w http.ResponseWriter, r *http.Request) {
+	job, ok := jc.job(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// the current State first, Clients may connect late
+	writeEvent(w, JobEvent{Type: "state", Status: job.Status()})
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// events may have been missed, the final State must not
+				writeEvent(w, JobEvent{Type: "state", Status: job.Status()})
+				flusher.Flush()
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event JobEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Err: %s", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// Cancel aborts the Job given by the {id} path value
+func (jc *JobsController) Cancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := jc.job(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := jc.manager.Cancel(job.Status().Id); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(&Notification{Data: "Der Scan kann nicht mehr abgebrochen werden.", Title: "KO!"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Continue resumes the manual Duplex Job given by the {id} path
+// value once the User flipped the Stack
+func (jc *JobsController) Continue(w http.ResponseWriter, r *http.Request) {
+	job, ok := jc.job(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := job.Continue(); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(&Notification{Data: "Der Scan wartet nicht auf das Wenden des Stapels.", Title: "KO!"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (jc *JobsController) job(r *http.Request) (*Job, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return nil, false
+	}
+	return jc.manager.Get(id)
+}
+
+// statusNotification explains why the Device cant scan
+// from the given source right now, nil if it can. Busy
+// Devices are fine, the Job waits in the Queue.
+func statusNotification(status *ScannerStatus, source string) *Notification {
+	title := "Scan kann nicht ausgeführt werden!"
+	switch {
+	case status.IsDown():
+		return &Notification{Data: "Der Scanner ist nicht bereit. Prüfe das Display des Scanners.", Title: title}
+	case isAdfSource(source) && status.IsAdfJammed():
+		return &Notification{Data: "Im Schnelleinzug gibt es einen Papierstau oder die Klappe ist offen.", Title: title}
+	case isAdfSource(source) && status.IsAdfEmpty():
+		return &Notification{Data: "Im Schnelleinzug liegt kein Papier.", Title: title}
+	}
+	return nil
+}
+
+// isAdfSource reports whether source feeds the ADF, simplex or duplex
+func isAdfSource(source string) bool {
+	return strings.EqualFold(source, "adf") || strings.EqualFold(source, "adf-duplex")
+}
+
+func NewJobsController(c *Config, devices *DevicesController, manager *JobManager) *JobsController {
+	return &JobsController{config: c, devices: devices, manager: manager}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeScanner hands out the given amount of PNG pages.
+// If block is set, NextPage blocks until the Job is cancelled.
+type fakeScanner struct {
+	pages int
+	block bool
+	// if set, StartJob waits until it is closed
+	start chan struct{}
+}
+
+type fakeJob struct {
+	scanner *fakeScanner
+	pages int
+	cancelled chan struct{}
+}
+
+func (s *fakeScanner) Capabilities() *ScanDevice {
+	return &ScanDevice{Id: "fake"}
+}
+
+func (s *fakeScanner) StartJob(dto *ScanSettingsDto) (ScannerJob, error) {
+	if s.start != nil {
+		<-s.start
+	}
+	return &fakeJob{scanner: s, pages: s.pages, cancelled: make(chan struct{})}, nil
+}
+
+func (s *fakeScanner) Status() (*ScannerStatus, error) {
+	return &ScannerStatus{State: ScannerStateIdle}, nil
+}
+
+func (s *fakeScanner) Validate(dto *ScanSettingsDto) error {
+	return nil
+}
+
+func (job *fakeJob) NextPage() (*ScanPage, error) {
+	if job.scanner.block {
+		<-job.cancelled
+		return nil, io.EOF
+	}
+	if job.pages == 0 {
+		return nil, io.EOF
+	}
+	job.pages--
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 20, 30)))
+	return &ScanPage{ContentType: "image/png", Data: buf.Bytes()}, nil
+}
+
+func (job *fakeJob) Cancel() error {
+	close(job.cancelled)
+	return nil
+}
+
+func waitForJob(t *testing.T, job *Job) JobStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.IsFinished() {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish, state: %s", job.Status().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return job.Status()
+}
+
+func TestJobRunsInBackground(t *testing.T) {
+	debug = new(bool)
+	config = &Config{}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	job := jm.Submit(&fakeScanner{pages: 3}, &ScanSettingsDto{}, false)
+
+	if _, ok := jm.Get(job.Status().Id); !ok {
+		t.Fatal("job not found")
+	}
+
+	status := waitForJob(t, job)
+
+	if status.State != JobStateDone {
+		t.Fatalf("expected state done, got %s: %s", status.State, status.Error)
+	}
+	if status.Pages != 3 {
+		t.Fatalf("expected 3 pages, got %d", status.Pages)
+	}
+	if status.URL == "" {
+		t.Fatal("download URL missing")
+	}
+}
+
+func TestJobCanBeCancelled(t *testing.T) {
+	debug = new(bool)
+	config = &Config{}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	job := jm.Submit(&fakeScanner{block: true}, &ScanSettingsDto{}, false)
+
+	if err := jm.Cancel(job.Status().Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := waitForJob(t, job); status.State != JobStateCancelled {
+		t.Fatalf("expected state cancelled, got %s", status.State)
+	}
+	if err := jm.Cancel(job.Status().Id); err == nil {
+		t.Fatal("finished job must not be cancelled again")
+	}
+}
+
+// newFakeSmtp accepts one Mail on a local Port, its Greeting is
+// held back until release is closed
+func newFakeSmtp(t *testing.T, release chan struct{}) *SmtpConfig {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+		fmt.Fprint(conn, "220 fake\r\n")
+		lines := bufio.NewScanner(conn)
+		data := false
+		for lines.Scan() {
+			switch line := lines.Text(); {
+			case data && line == ".":
+				data = false
+				fmt.Fprint(conn, "250 queued\r\n")
+			case data:
+			case line == "DATA":
+				data = true
+				fmt.Fprint(conn, "354 go ahead\r\n")
+			case line == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+	return &SmtpConfig{
+		Host: &url.URL{Path: "127.0.0.1"},
+		Port: l.Addr().(*net.TCPAddr).Port,
+		User: "scanbridge",
+		Pass: "secret",
+		Sender: "scanbridge@example.org",
+		Recipient: "office@example.org",
+		Subject: "Scan",
+	}
+}
+
+func TestJobCannotBeCancelledWhileDelivering(t *testing.T) {
+	debug = new(bool)
+	release := make(chan struct{})
+	config = &Config{Smtp: newFakeSmtp(t, release)}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	job := jm.Submit(&fakeScanner{pages: 1}, &ScanSettingsDto{}, false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status().State != JobStateDelivering {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not deliver, state: %s", job.Status().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := jm.Cancel(job.Status().Id); err == nil {
+		t.Fatal("delivering job must not be cancelled")
+	}
+	close(release)
+
+	status := waitForJob(t, job)
+	if status.State != JobStateDone {
+		t.Fatalf("expected state done, got %s: %s", status.State, status.Error)
+	}
+	if _, err := os.Stat(filepath.Join(jm.storageDir, status.Id.String()+".pdf")); err != nil {
+		t.Fatalf("PDF of the delivered job removed: %s", err)
+	}
+}
+
+func TestCancelledJobDoesNotStartOnDevice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForDevice(ctx, &fakeScanner{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled job to stop, got %v", err)
+	}
+}
+
+func TestJobsOfSameDeviceAreSerialized(t *testing.T) {
+	debug = new(bool)
+	config = &Config{}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	scanner := &fakeScanner{block: true}
+	first := jm.Submit(scanner, &ScanSettingsDto{}, false)
+	second := jm.Submit(scanner, &ScanSettingsDto{}, false)
+	third := jm.Submit(scanner, &ScanSettingsDto{}, false)
+
+	if pos := third.Status().QueuePosition; pos != 2 {
+		t.Fatalf("expected queue position 2, got %d", pos)
+	}
+
+	// a waiting job leaves the queue right away
+	if err := jm.Cancel(second.Status().Id); err != nil {
+		t.Fatal(err)
+	}
+	if state := second.Status().State; state != JobStateCancelled {
+		t.Fatalf("expected state cancelled, got %s", state)
+	}
+	if pos := third.Status().QueuePosition; pos != 1 {
+		t.Fatalf("expected queue position 1, got %d", pos)
+	}
+
+	if err := jm.Cancel(first.Status().Id); err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, first)
+
+	// the next job starts once the device is free
+	deadline := time.Now().Add(5 * time.Second)
+	for third.Status().State != JobStateScanning {
+		if time.Now().After(deadline) {
+			t.Fatalf("third job did not start, state: %s", third.Status().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pos := third.Status().QueuePosition; pos != 0 {
+		t.Fatalf("expected queue position 0, got %d", pos)
+	}
+	jm.Cancel(third.Status().Id)
+	waitForJob(t, third)
+}
+
+func TestJobEventsAreStreamed(t *testing.T) {
+	debug = new(bool)
+	config = &Config{}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	scanner := &fakeScanner{pages: 2, start: make(chan struct{})}
+	job := jm.Submit(scanner, &ScanSettingsDto{}, false)
+
+	jc := NewJobsController(config, nil, jm)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/jobs/{id}/events", jc.Events)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/jobs/" + job.Status().Id.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected Content-Type %s", ct)
+	}
+	close(scanner.start)
+
+	var events []JobEvent
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		data, ok := strings.CutPrefix(lines.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event JobEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	pages := 0
+	for _, event := range events {
+		if event.Type == "page" {
+			pages++
+			if !strings.HasPrefix(event.Thumbnail, "data:image/jpeg;base64,") {
+				t.Fatalf("page event without thumbnail")
+			}
+		}
+	}
+	if pages != 2 {
+		t.Fatalf("expected 2 page events, got %d", pages)
+	}
+	if last := events[len(events)-1]; last.Status.State != JobStateDone {
+		t.Fatalf("expected final state done, got %s", last.Status.State)
+	}
+}
+
+func TestManualDuplexWaitsForFlip(t *testing.T) {
+	debug = new(bool)
+	config = &Config{}
+
+	jm := NewJobManager()
+	jm.storageDir = t.TempDir()
+	job := jm.Submit(&fakeScanner{pages: 2}, &ScanSettingsDto{}, true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status().State != JobStateWaitingForFlip {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not wait for flip, state: %s", job.Status().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := job.Continue(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForJob(t, job)
+	if status.State != JobStateDone {
+		t.Fatalf("expected state done, got %s: %s", status.State, status.Error)
+	}
+	if status.Pages != 4 {
+		t.Fatalf("expected 4 pages, got %d", status.Pages)
+	}
+	if err := job.Continue(); err == nil {
+		t.Fatal("finished job must not be continued")
+	}
+}
+
+func TestInterleaveDuplex(t *testing.T) {
+	pages, err := interleaveDuplex([]string{"f1", "f2", "f3"}, []string{"b3", "b2", "b1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(pages, ",") != "f1,b1,f2,b2,f3,b3" {
+		t.Fatalf("unexpected order %v", pages)
+	}
+	if _, err := interleaveDuplex([]string{"f1", "f2"}, []string{"b1"}); err == nil {
+		t.Fatal("expected error for missing back side")
+	}
+}
+
+func TestManualDuplexRequiresSimplexAdf(t *testing.T) {
+	dev := &ScanDevice{Is: []string{"platen", "adf", "adf-duplex"}, Cs: []ColorMode{ColorModeGray}}
+	for _, tc := range []struct {
+		source string
+		duplex bool
+		valid bool
+	}{
+		{"adf", false, true},
+		{"platen", false, false},
+		{"adf", true, false},
+		{"adf-duplex", false, false},
+	} {
+		dto, err := dev.DefaultSettings(tc.source, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applyOverrides(dto, &jobRequest{Duplex: tc.duplex, ManualDuplex: true}, dev)
+		var errs ValidationErrors
+		if tc.valid != (err == nil) || (err != nil && (!errors.As(err, &errs) || errs[0].Field != "ManualDuplex")) {
+			t.Fatalf("unexpected result for %s duplex %t: %v", tc.source, tc.duplex, err)
+		}
+	}
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"image/png"
 	"io"
 	"io/fs"
 	"log"
@@ -15,12 +15,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/google/uuid"
-	"github.com/jung-kurt/gofpdf"
 )
 
 //go:embed web/dist/app.js
@@ -34,16 +30,12 @@ var config *Config
 const pdfStorageDir string = "/var/tmp/scanbridge"
 
 var env *Environment
-var scanimageBin *string
-var deviceURI *string
-var deviceSource *string
+var devicesCtrl *DevicesController
 var scanFormat string = "png"
-var scanResolution int16 = 200
+var scanResolution int = 200
 
 func main() {
 
-	//scanimageBin = flag.String("scanimage", "", "scanimage binary path. If not set, autodiscover is used. E.g.: /usr/bin/scanimage")
-	//deviceSource = flag.String("source", "ADF", "scan source to query from")
 	debug = flag.Bool("debug", false, "enable debug mode")
 	bindingAddrPort := flag.String("bind", "127.0.0.1:8080", "Binding to")
 	configFile := flag.String("config", "", "configfile")
@@ -68,7 +60,34 @@ func main() {
 		log.Println("DEBUG:no smpt-config provided, we wont send Mails!")
 	}
 
+	for _, dev := range config.Devices {
+		if dev.Backend == BackendSane && config.Scanimage == "" {
+			config.Scanimage = *mustResolveBinary("scanimage")
+		}
+	}
+
+	// OCR is optional, tesseract may be missing
+	if config.Tesseract == "" {
+		if path, err := exec.LookPath("tesseract"); err == nil {
+			config.Tesseract = path
+		}
+	}
+
+	trustStore, err := NewTrustStore(config.TrustStore)
+	if err != nil {
+		log.Fatalln("Error loading trust store:", err)
+	}
+	for _, dev := range config.Devices {
+		if err := dev.initClient(trustStore); err != nil {
+			log.Fatalln("Error configuring device", dev.Id, err)
+		}
+	}
+
 	env = NewEnvironment(config)
+	registry := NewDeviceRegistry(config, trustStore)
+	go registry.Run(context.Background())
+	devicesCtrl = NewDevicesController(config, registry)
+	jobsCtrl := NewJobsController(config, devicesCtrl, NewJobManager())
 
 	bindAddrPort := netip.MustParseAddrPort(*bindingAddrPort)
 	log.Printf("Starting webserver on %s...", bindAddrPort.String())
@@ -79,9 +98,16 @@ func main() {
 	webJsSub, _ := fs.Sub(webJs, "web/dist")
 	http.Handle("/app.js", http.FileServer(http.FS(webJsSub)))
 	
-	http.Handle("/api/devices", NewDevicesController(config))
+	http.Handle("/api/devices", devicesCtrl)
+	http.HandleFunc("GET /api/devices/{id}/status", devicesCtrl.ServeStatus)
+	http.HandleFunc("GET /api/devices/{id}/capabilities", devicesCtrl.ServeCapabilities)
 	http.HandleFunc("/api/env", envCtrl)
-	http.HandleFunc("/api/scan", scanCtrl)
+	http.HandleFunc("GET /api/papersizes", paperSizesCtrl)
+	http.HandleFunc("POST /api/jobs", jobsCtrl.Create)
+	http.HandleFunc("GET /api/jobs/{id}", jobsCtrl.Show)
+	http.HandleFunc("GET /api/jobs/{id}/events", jobsCtrl.Events)
+	http.HandleFunc("DELETE /api/jobs/{id}", jobsCtrl.Cancel)
+	http.HandleFunc("POST /api/jobs/{id}/continue", jobsCtrl.Continue)
 	http.HandleFunc("/api/download/", pdfDownloadCtrl)
 	log.Fatalln(http.ListenAndServe(bindAddrPort.String(), nil))
 }
@@ -128,26 +154,6 @@ type Notification struct {
 	URL string `json:"url"`
 }
 
-func scanCtrl(w http.ResponseWriter, r *http.Request) {
-	
-	mode := r.URL.Query().Get("mode")
-	if mode == "" {
-		mode = "Color"
-	}
-	uuid, err := scan(mode)
-	if err != nil {
-		log.Printf("Err: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(&Notification{Data: "Prüfe, ob der Scanner eingeschalten ist (Ein/Aus-Taste darf nicht blinken) und Papier im Schnelleinzug liegt. Beim Einlegen des Papiers wird der Scanner ein kurzen Ton wiedergeben.", Title: "Scan kann nicht ausgeführt werden!"})
-		return
-	}
-	json.NewEncoder(w).Encode(&Notification{
-		Data: "Der Scan war erfolgreich!", 
-		Title: "OK!",
-		URL: fmt.Sprintf("/api/download/%s", uuid.String()),
-	})
-}
-
 func envCtrl(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(env); err != nil {
@@ -155,131 +161,213 @@ func envCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func scan(mode string) (uuid.UUID, error) {
+// scan runs the Job on its Scanner, builds the PDF in storageDir
+// and mails it. Once ctx is cancelled, the Job is cancelled on the Device and
+// all intermediate Files of the Scan are removed.
+func scan(ctx context.Context, job *Job, storageDir string) error {
+
+	id := job.Status().Id
+	scanner := job.scanner
+	dto := job.dto
 
-	uuid := uuid.New()	
 	cwd, err := os.MkdirTemp("", "scanbridge*")
 	if err != nil {
 		log.Printf("Err: %s", err)
-		return uuid, err
+		return err
 	}
 
-	if *debug == false {
-		defer os.RemoveAll(cwd)
+	pdfFileName := filepath.Join(storageDir, fmt.Sprintf("%s.pdf", id.String()))
+
+	defer func() {
+		if ctx.Err() != nil {
+			log.Println("id", id.String(), "cancelled")
+			os.RemoveAll(cwd)
+			os.Remove(pdfFileName)
+		} else if *debug == false {
+			os.RemoveAll(cwd)
+		}
+	}()
+
+	log.Println("id", id.String(), "device:", scanner.Capabilities().Id, "scanTo:", cwd, "Mode:", dto.ColorMode)
+
+	pages, err := scanBatch(ctx, job, cwd, "front")
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages scanned")
 	}
 
-	log.Println("id", uuid.String(), "scanTo:", cwd, "Mode:", mode)
-		
-	// Create command.
-	cmd := exec.Command(
-		*scanimageBin,
-		fmt.Sprintf("--device-name=%s", *deviceURI),
-		fmt.Sprintf("--source=%s", *deviceSource),
-		fmt.Sprintf("--format=%s", scanFormat),
-		fmt.Sprintf("--resolution=%d", scanResolution),
-		fmt.Sprintf("--batch=%s/%%d.png", cwd),
-		fmt.Sprintf("--mode=%s", mode),
-		"--batch-start=10",
-	)
+	if job.Status().ManualDuplex {
+		if err := job.waitForFlip(ctx); err != nil {
+			return err
+		}
+		backs, err := scanBatch(ctx, job, cwd, "back")
+		if err != nil {
+			return err
+		}
+		pages, err = interleaveDuplex(pages, backs)
+		if err != nil {
+			return err
+		}
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		log.Printf("Err: %s | %s", err, stderr.String())
-		return uuid, err
+	// number the Pages in Document order, kept in debug mode
+	for n, page := range pages {
+		pages[n] = filepath.Join(cwd, fmt.Sprintf("%04d%s", n+1, filepath.Ext(page)))
+		if err := os.Rename(page, pages[n]); err != nil {
+			return err
+		}
 	}
 
-	err = os.MkdirAll(pdfStorageDir, 0700)
+	job.setState(JobStateProcessing)
+	// from now on Cancel refuses, see JobManager.Cancel
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err = os.MkdirAll(storageDir, 0700)
 	if err != nil {
-		return uuid, err
+		return err
+	}
+	var texts []*ocrPage
+	if dto.OcrLanguage != "" && !scanner.Capabilities().ocrsOnDevice(dto) {
+		texts, err = recognize(ctx, config.Tesseract, pages, dto.OcrLanguage, dto.XResolution)
+		if err != nil {
+			log.Printf("Err: %s", err)
+			return err
+		}
 	}
-	pdfFileName := filepath.Join(pdfStorageDir, fmt.Sprintf("%s.pdf", uuid.String()))
-	err = pngsToPDF(cwd, pdfFileName)
+	err = pagesToPDF(pages, texts, dto.XResolution, dto.YResolution, config.jpegQuality(), pdfFileName)
 	if err != nil {
 		log.Printf("Err: %s", err)
-		return uuid, err
+		return err
 	}
 	
 	log.Println("PDF-File generated:", pdfFileName)
 
-	smtpService, err := NewSmtpService(config)
+	job.setState(JobStateDelivering)
+	return deliver(pdfFileName)
+}
+
+// scanBatch scans all Pages of one Scanner Job into cwd and
+// returns their Files in scan order
+func scanBatch(ctx context.Context, job *Job, cwd string, prefix string) ([]string, error) {
 
+	job.setState(JobStateScanning)
+	scanJob, err := job.scanner.StartJob(job.dto)
 	if err != nil {
-		if *debug == true {
-			log.Println("DEBUG:send mail to", smtpService.config.Smtp.Recipient)
+		log.Printf("Err: %s", err)
+		return nil, err
+	}
+
+	stopCancel := context.AfterFunc(ctx, func() {
+		if err := scanJob.Cancel(); err != nil {
+			log.Printf("Err: cancel failed: %s", err)
+		}
+	})
+	defer stopCancel()
+
+	var files []string
+	for n := 1; ; n++ {
+		page, err := scanJob.NextPage()
+		// a cancelled Job may look like a finished one
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == io.EOF {
+			return files, nil
 		}
-		err := smtpService.SendMail(pdfFileName)
 		if err != nil {
 			log.Printf("Err: %s", err)
-			return uuid, err
-		} else if *debug == true {
-			log.Println("DEBUG:mail successfully sent to", smtpService.config.Smtp.Recipient)
+			return nil, err
+		}
+		pageFile := filepath.Join(cwd, fmt.Sprintf("%s-%04d%s", prefix, n, pageExt(page)))
+		if err := os.WriteFile(pageFile, page.Data, 0600); err != nil {
+			return nil, err
 		}
-	} else if *debug == true {
-		log.Println("DEBUG:omit send mail:no smtp configured")
+		files = append(files, pageFile)
+		job.addPage(page)
 	}
-
-	return uuid, err
 }
 
-func mustResolveBinary(bin string) *string {
-	path, err := exec.LookPath(bin);
-	if err != nil {
-		log.Fatalf("cant locate %s. Please install!", bin)
+// interleaveDuplex merges the front Sides with the back Sides of
+// the flipped Stack. The back Sides come in reverse order, the
+// back of the last Sheet is scanned first.
+func interleaveDuplex(fronts []string, backs []string) ([]string, error) {
+	if len(fronts) != len(backs) {
+		return nil, fmt.Errorf("got %d back sides for %d front sides", len(backs), len(fronts))
 	}
-	return  &path
+	pages := make([]string, 0, len(fronts)+len(backs))
+	for i, front := range fronts {
+		pages = append(pages, front, backs[len(backs)-1-i])
+	}
+	return pages, nil
 }
 
-func pngsToPDF(cwd string, pdfPath string) error {
+// deliver mails the PDF, if SMTP is configured
+func deliver(pdfFileName string) error {
 
-	var pngFiles []string
-	entries, err := os.ReadDir(cwd)
+	if config.Smtp == nil {
+		if *debug == true {
+			log.Println("DEBUG:omit send mail:no smtp configured")
+		}
+		return nil
+	}
+
+	smtpService, err := NewSmtpService(config)
 	if err != nil {
 		return err
 	}
 
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		if filepath.Ext(e.Name()) == fmt.Sprintf(".%s", scanFormat) {
-			pngFiles = append(
-				pngFiles,
-				filepath.Join(cwd, e.Name()),
-			)
-		}
+	if *debug == true {
+		log.Println("DEBUG:send mail to", smtpService.config.Smtp.Recipient)
 	}
-	sort.Strings(pngFiles)
-
-	pdf := gofpdf.New("P", "pt", "A4", "")
-	pdf.SetAutoPageBreak(false, 0)
-
-	for _, file := range pngFiles {
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-
-		img, err := png.DecodeConfig(f)
-		f.Close()
-		if err != nil {
-			return err
-		}
+	if err := smtpService.SendMail(pdfFileName); err != nil {
+		log.Printf("Err: %s", err)
+		return err
+	}
+	if *debug == true {
+		log.Println("DEBUG:mail successfully sent to", smtpService.config.Smtp.Recipient)
+	}
+	return nil
+}
 
-		w := float64(img.Width)
-		h := float64(img.Height)
+// pageExt returns the file extension of a scanned page. Pages
+// without or with a generic Content-Type are sniffed.
+func pageExt(page *ScanPage) string {
+	if ext, ok := mediaTypeExt(page.ContentType); ok {
+		return ext
+	}
+	// http.DetectContentType knows no TIFF
+	if bytes.HasPrefix(page.Data, []byte("II*\x00")) || bytes.HasPrefix(page.Data, []byte("MM\x00*")) {
+		return ".tiff"
+	}
+	if ext, ok := mediaTypeExt(http.DetectContentType(page.Data)); ok {
+		return ext
+	}
+	return ".bin"
+}
 
-		pdf.AddPageFormat("P", gofpdf.SizeType{Wd: w, Ht: h})
-		pdf.ImageOptions(
-			file,
-			0, 0,
-			w, h,
-			false,
-			gofpdf.ImageOptions{ImageType: "PNG"},
-			0,
-			"",
-		)
+// mediaTypeExt maps the Content-Type of a page to its extension
+func mediaTypeExt(contentType string) (string, bool) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "image/png":
+		return ".png", true
+	case "image/jpeg":
+		return ".jpg", true
+	case "image/tiff":
+		return ".tiff", true
+	case "application/pdf":
+		return ".pdf", true
 	}
+	return "", false
+}
 
-	return pdf.OutputFileAndClose(pdfPath)
+func mustResolveBinary(bin string) *string {
+	path, err := exec.LookPath(bin);
+	if err != nil {
+		log.Fatalf("cant locate %s. Please install!", bin)
+	}
+	return  &path
 }
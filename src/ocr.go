@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// tesseract Languages, e.g. deu or deu+eng
+var ocrLanguagePattern = regexp.MustCompile(`^[a-z_]+(\+[a-z_]+)*$`)
+
+// tesseract Languages by their ISO 639-1 Code, which the
+// OCRLanguageSupport of eSCL-Devices is given in
+var ocrDeviceLanguages = map[string]string{
+	"deu": "de",
+	"eng": "en",
+	"fra": "fr",
+	"ita": "it",
+	"spa": "es",
+	"nld": "nl",
+	"por": "pt",
+	"pol": "pl",
+}
+
+// ocrPage is the Text recognized on a Page Image
+type ocrPage struct {
+	// Size of the Image in Pixels
+	Width int
+	Height int
+	Words []ocrWord
+}
+
+// ocrWord is a recognized Word and its Bounding Box in Pixels
+type ocrWord struct {
+	Text string
+	X0, Y0, X1, Y1 int
+}
+
+// ocrLanguage returns the Language the Device recognizes the
+// tesseract Language lang in. ok is false if the Device has no
+// OCR or lacks the Language. Of several Languages the first
+// is used.
+func (sd *ScanDevice) ocrLanguage(lang string) (string, bool) {
+	if !sd.OCR {
+		return "", false
+	}
+	// the Device decides on its own
+	if len(sd.OCRLanguages) == 0 {
+		return "", true
+	}
+	iso, ok := ocrDeviceLanguages[strings.Split(lang, "+")[0]]
+	if !ok {
+		return "", false
+	}
+	for _, supported := range sd.OCRLanguages {
+		if strings.HasPrefix(strings.ToLower(supported), iso) {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// ocrsOnDevice reports whether the Device delivers searchable
+// PDFs for the dto, otherwise tesseract has to recognize the Text
+func (sd *ScanDevice) ocrsOnDevice(dto *ScanSettingsDto) bool {
+	if dto.OcrLanguage == "" || dto.DocumentFormat != "application/pdf" {
+		return false
+	}
+	_, ok := sd.ocrLanguage(dto.OcrLanguage)
+	return ok
+}
+
+// recognize runs tesseract on all Image Pages. The result has an
+// Entry for each Page, nil for PDFs. It fails if tesseract fails
+// on any Page, e.g. if the Language is not installed, as a PDF
+// without Text Layer is not what was asked for.
+func recognize(ctx context.Context, tesseract string, pages []string, lang string, dpi int) ([]*ocrPage, error) {
+	texts := make([]*ocrPage, len(pages))
+	for n, page := range pages {
+		if strings.ToLower(filepath.Ext(page)) == ".pdf" {
+			continue
+		}
+		text, err := runTesseract(ctx, tesseract, page, lang, dpi)
+		if err != nil {
+			return nil, fmt.Errorf("OCR of page %d failed: %w", n+1, err)
+		}
+		texts[n] = text
+	}
+	return texts, nil
+}
+
+// runTesseract recognizes the Text of the Image as hOCR
+func runTesseract(ctx context.Context, tesseract string, image string, lang string, dpi int) (*ocrPage, error) {
+	args := []string{image, "stdout", "-l", lang}
+	if dpi > 0 {
+		args = append(args, "--dpi", strconv.Itoa(dpi))
+	}
+	args = append(args, "hocr")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tesseract, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseHOCR(&stdout)
+}
+
+// parseHOCR reads the first ocr_page and its ocrx_word Elements,
+// see http://kba.github.io/hocr-spec/1.2/
+func parseHOCR(r io.Reader) (*ocrPage, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var page *ocrPage
+	// Depth of the open ocrx_word Element, 0 if none
+	wordDepth, depth := 0, 0
+	var word *ocrWord
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			class, title := "", ""
+			for _, attr := range t.Attr {
+				switch attr.Name.Local {
+				case "class":
+					class = attr.Value
+				case "title":
+					title = attr.Value
+				}
+			}
+			box, ok := hocrBBox(title)
+			switch {
+			case class == "ocr_page" && page == nil && ok:
+				page = &ocrPage{Width: box[2] - box[0], Height: box[3] - box[1]}
+			case class == "ocrx_word" && page != nil && ok:
+				word = &ocrWord{X0: box[0], Y0: box[1], X1: box[2], Y1: box[3]}
+				wordDepth = depth
+			}
+		case xml.CharData:
+			if word != nil {
+				word.Text += string(t)
+			}
+		case xml.EndElement:
+			if word != nil && depth == wordDepth {
+				if text := strings.TrimSpace(word.Text); text != "" {
+					word.Text = text
+					page.Words = append(page.Words, *word)
+				}
+				word = nil
+			}
+			depth--
+		}
+	}
+
+	if page == nil {
+		return nil, fmt.Errorf("no ocr_page found")
+	}
+	return page, nil
+}
+
+// hocrBBox reads the bbox Property of an hOCR title,
+// e.g. "bbox 10 20 110 50; x_wconf 96"
+func hocrBBox(title string) ([4]int, bool) {
+	var box [4]int
+	for _, prop := range strings.Split(title, ";") {
+		fields := strings.Fields(prop)
+		if len(fields) != 5 || fields[0] != "bbox" {
+			continue
+		}
+		for n, field := range fields[1:] {
+			v, err := strconv.Atoi(field)
+			if err != nil {
+				return box, false
+			}
+			box[n] = v
+		}
+		return box, box[2] > box[0] && box[3] > box[1]
+	}
+	return box, false
+}
+
+// addTextLayer puts the recognized Words invisibly onto the
+// current Page, each stretched to its Box on the Page Image, so
+// the PDF becomes searchable
+func addTextLayer(pdf *gofpdf.Fpdf, page *ocrPage) {
+	if page == nil || page.Width <= 0 || page.Height <= 0 {
+		return
+	}
+	w, h := pdf.GetPageSize()
+	sx, sy := w/float64(page.Width), h/float64(page.Height)
+	// the core Fonts are cp1252 encoded
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetTextRenderingMode(3)
+	for _, word := range page.Words {
+		text := tr(word.Text)
+		// the Baseline is the Bottom of the Box
+		x, y := float64(word.X0)*sx, float64(word.Y1)*sy
+		pdf.SetFontSize(float64(word.Y1-word.Y0) * sy)
+		width := pdf.GetStringWidth(text)
+		if width <= 0 {
+			continue
+		}
+		pdf.TransformBegin()
+		pdf.TransformScaleX(float64(word.X1-word.X0)*sx/width*100, x, y)
+		pdf.Text(x, y, text)
+		pdf.TransformEnd()
+	}
+	pdf.SetTextRenderingMode(0)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const testHOCR = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+ <head>
+  <meta http-equiv="Content-Type" content="text/html;charset=utf-8"/>
+  <meta name="ocr-system" content="tesseract 5.3.0" />
+ </head>
+ <body>
+  <div class='ocr_page' id='page_1' title='image "0001.png"; bbox 0 0 1654 2339; ppageno 0'>
+   <span class='ocr_line' id='line_1_1' title="bbox 100 200 700 250; baseline 0 -8">
+    <span class='ocrx_word' id='word_1_1' title='bbox 100 200 400 250; x_wconf 96'>Rechnung</span>
+    <span class='ocrx_word' id='word_1_2' title='bbox 420 200 700 250; x_wconf 91'><strong>Müller&amp;Söhne</strong></span>
+    <span class='ocrx_word' id='word_1_3' title='bbox 720 200 730 250; x_wconf 10'> </span>
+   </span>
+  </div>
+ </body>
+</html>
+`
+
+func TestParseHOCR(t *testing.T) {
+	page, err := parseHOCR(strings.NewReader(testHOCR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Width != 1654 || page.Height != 2339 {
+		t.Fatalf("expected 1654x2339 page, got %dx%d", page.Width, page.Height)
+	}
+	expected := []ocrWord{
+		{Text: "Rechnung", X0: 100, Y0: 200, X1: 400, Y1: 250},
+		{Text: "Müller&Söhne", X0: 420, Y0: 200, X1: 700, Y1: 250},
+	}
+	if len(page.Words) != len(expected) {
+		t.Fatalf("expected %d words, got %v", len(expected), page.Words)
+	}
+	for n, word := range expected {
+		if page.Words[n] != word {
+			t.Fatalf("expected %v, got %v", word, page.Words[n])
+		}
+	}
+
+	if _, err := parseHOCR(strings.NewReader("<html><body></body></html>")); err == nil {
+		t.Fatal("expected hOCR without page to fail")
+	}
+}
+
+func TestRecognizeRunsTesseract(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	hocr := filepath.Join(dir, "page.hocr")
+	if err := os.WriteFile(hocr, []byte(testHOCR), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tesseract := filepath.Join(dir, "tesseract")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\ncat " + hocr + "\n"
+	if err := os.WriteFile(tesseract, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []string{filepath.Join(dir, "0001.png"), filepath.Join(dir, "0002.pdf")}
+	texts, err := recognize(context.Background(), tesseract, pages, "deu+eng", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] == nil || texts[1] != nil {
+		t.Fatalf("expected text of the image only, got %v", texts)
+	}
+	if len(texts[0].Words) != 2 {
+		t.Fatalf("expected 2 words, got %v", texts[0].Words)
+	}
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != pages[0]+" stdout -l deu+eng --dpi 300 hocr" {
+		t.Fatalf("unexpected tesseract arguments %s", got)
+	}
+
+	// e.g. the Language Pack is missing
+	failing := "#!/bin/sh\necho \"Failed loading language 'xyz'\" >&2\nexit 1\n"
+	if err := os.WriteFile(tesseract, []byte(failing), 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, err = recognize(context.Background(), tesseract, pages, "xyz", 300)
+	if err == nil || !strings.Contains(err.Error(), "Failed loading language") {
+		t.Fatalf("expected OCR to fail, got %v", err)
+	}
+}
+
+func TestAddTextLayerIsInvisible(t *testing.T) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetCompression(false)
+	pdf.AddPage()
+	page, err := parseHOCR(strings.NewReader(testHOCR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTextLayer(pdf, page)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"3 Tr", "(Rechnung) Tj", "(M\xfcller&S\xf6hne) Tj"} {
+		if !bytes.Contains(buf.Bytes(), []byte(expected)) {
+			t.Fatalf("%q missing in text layer", expected)
+		}
+	}
+}
+
+func TestOcrIsDoneByDeviceOrTesseract(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Location", "/eSCL/ScanJobs/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dev := &ScanDevice{
+		URL: server.URL + "/eSCL",
+		Version: "2.6",
+		Cs: []ColorMode{ColorModeGray},
+		Is: []string{"platen"},
+		Pdl: []string{"application/pdf", "image/jpeg"},
+		OCR: true,
+		OCRLanguages: []string{"en-US", "de-DE"},
+		client: server.Client(),
+	}
+
+	dto, err := dev.DefaultSettings("platen", "Document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOcr(dto, &jobRequest{OcrLanguage: "deu"}, dev, ""); err != nil {
+		t.Fatal(err)
+	}
+	if dto.DocumentFormat != "application/pdf" || !dev.ocrsOnDevice(dto) {
+		t.Fatal("expected OCR by the device")
+	}
+	if _, err := dev.NewScanJob(dto); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("<scan:OCRLanguage>de-DE</scan:OCRLanguage>")) {
+		t.Fatalf("scan:OCRLanguage missing in %s", body)
+	}
+
+	// Languages unknown to the Device are left to tesseract,
+	// which reads Images only
+	dto, _ = dev.DefaultSettings("platen", "Document")
+	if err := applyOcr(dto, &jobRequest{OcrLanguage: "fra"}, dev, ""); err == nil {
+		t.Fatal("expected OCR without tesseract to fail")
+	}
+	if err := applyOcr(dto, &jobRequest{OcrLanguage: "fra"}, dev, "/usr/bin/tesseract"); err != nil {
+		t.Fatal(err)
+	}
+	if dto.DocumentFormat != "image/jpeg" || dev.ocrsOnDevice(dto) {
+		t.Fatalf("expected OCR by tesseract on JPEGs, got %s", dto.DocumentFormat)
+	}
+
+	dto, _ = dev.DefaultSettings("platen", "Document")
+	req := &jobRequest{OcrLanguage: "fra", Format: "application/pdf"}
+	if err := applyOcr(dto, req, dev, "/usr/bin/tesseract"); err == nil {
+		t.Fatal("expected PDF to fail for OCR by tesseract")
+	}
+	if err := applyOcr(dto, &jobRequest{OcrLanguage: "deu; rm"}, dev, "/usr/bin/tesseract"); err == nil {
+		t.Fatal("expected invalid language to fail")
+	}
+}
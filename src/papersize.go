@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// PaperSize is a Paper Format offered for Scanning, sizes in mm
+type PaperSize struct {
+	Name string `json:"name"`
+	Label string `json:"label"`
+	Width float64 `json:"width_mm"`
+	Height float64 `json:"height_mm"`
+}
+
+const (
+	// PaperSizeCustom takes the Size in mm from the Request
+	PaperSizeCustom = "custom"
+	// PaperSizeAuto scans the max Area and lets the Device detect
+	// the Edges, ADF Sources with EdgeAutoDetection only
+	PaperSizeAuto = "auto"
+	// used if no PaperSize is requested
+	defaultPaperSize = "a4"
+)
+
+// paperSizes is the Catalog of supported PaperSizes
+var paperSizes = []PaperSize{
+	{Name: "a4", Label: "A4", Width: 210, Height: 297},
+	{Name: "a5", Label: "A5", Width: 148, Height: 210},
+	{Name: "letter", Label: "Letter", Width: 215.9, Height: 279.4},
+	{Name: "legal", Label: "Legal", Width: 215.9, Height: 355.6},
+	{Name: "business-card", Label: "Visitenkarte", Width: 85, Height: 55},
+}
+
+// mmToThreeHundredths converts mm to escl:ThreeHundredthsOfInches
+func mmToThreeHundredths(mm float64) int {
+	return int(math.Round(mm / 25.4 * 300))
+}
+
+// scanRegionFor returns Width and Height in 1/300 inch of the
+// named PaperSize, or of widthMm x heightMm for PaperSizeCustom.
+// The Region is clamped to the Limits of the InputSource, if known.
+func scanRegionFor(name string, widthMm, heightMm float64, caps *InputSourceCaps) (width int, height int, err error) {
+
+	var errs ValidationErrors
+	switch name = strings.ToLower(name); name {
+	case "":
+		return scanRegionFor(defaultPaperSize, 0, 0, caps)
+	case PaperSizeAuto:
+		if caps == nil || len(caps.EdgeAutoDetection) == 0 {
+			errs.add("PaperSize", "auto detection of the paper size is not supported by the InputSource")
+			return 0, 0, errs
+		}
+		return caps.MaxWidth, caps.MaxHeight, nil
+	case PaperSizeCustom:
+		if widthMm <= 0 || heightMm <= 0 {
+			errs.add("PaperSize", "custom paper size requires width_mm and height_mm")
+			return 0, 0, errs
+		}
+	default:
+		i := slices.IndexFunc(paperSizes, func(ps PaperSize) bool { return ps.Name == name })
+		if i < 0 {
+			errs.add("PaperSize", "unknown paper size %s", name)
+			return 0, 0, errs
+		}
+		widthMm, heightMm = paperSizes[i].Width, paperSizes[i].Height
+	}
+
+	width, height = mmToThreeHundredths(widthMm), mmToThreeHundredths(heightMm)
+	if caps != nil && caps.MaxWidth > 0 && caps.MaxHeight > 0 {
+		width = min(max(width, caps.MinWidth), caps.MaxWidth)
+		height = min(max(height, caps.MinHeight), caps.MaxHeight)
+	}
+	return width, height, nil
+}
+
+// paperSizesCtrl lists the Catalog of PaperSizes
+func paperSizesCtrl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(paperSizes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
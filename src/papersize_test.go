@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestScanRegionForPaperSize(t *testing.T) {
+	platen := &InputSourceCaps{MinWidth: 295, MaxWidth: 2550, MinHeight: 295, MaxHeight: 3507}
+
+	width, height, err := scanRegionFor("A4", 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 2480 || height != 3508 {
+		t.Fatalf("unexpected A4 region %dx%d", width, height)
+	}
+
+	// clamped to the platen
+	width, height, err = scanRegionFor("", 0, 0, platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 2480 || height != 3507 {
+		t.Fatalf("unexpected clamped A4 region %dx%d", width, height)
+	}
+	width, height, err = scanRegionFor("custom", 10, 400, platen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 295 || height != 3507 {
+		t.Fatalf("unexpected clamped custom region %dx%d", width, height)
+	}
+
+	if _, _, err := scanRegionFor("auto", 0, 0, platen); err == nil {
+		t.Fatal("auto requires edge detection")
+	}
+	adf := &InputSourceCaps{MaxWidth: 2550, MaxHeight: 4200, EdgeAutoDetection: []string{"TopEdge"}}
+	width, height, err = scanRegionFor("auto", 0, 0, adf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 2550 || height != 4200 {
+		t.Fatalf("unexpected auto region %dx%d", width, height)
+	}
+
+	if _, _, err := scanRegionFor("a3", 0, 0, nil); err == nil {
+		t.Fatal("expected unknown paper size to fail")
+	}
+}
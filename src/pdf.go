@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+	_ "golang.org/x/image/tiff"
+)
+
+// pagesToPDF assembles the scanned Pages in the given order into
+// pdfPath. Pages may be JPEG, PNG, TIFF or PDF and may be mixed.
+// JPEGs are embedded without recompression, the Pages of PDFs
+// delivered by the Device are imported as they are. Other Images
+// are compressed with CCITT G4 if black and white, otherwise as
+// JPEG of jpegQuality. Images get their physical Size from the
+// Resolution stored in the Image, or from xDpi and yDpi they were
+// scanned with. texts holds the recognized Text by Page, which is
+// laid invisibly over the Image, it may be nil.
+func pagesToPDF(pages []string, texts []*ocrPage, xDpi int, yDpi int, jpegQuality int, pdfPath string) (err error) {
+
+	// gofpdi panics on malformed PDFs
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cant import PDF: %v", r)
+		}
+	}()
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	importer := gofpdi.NewImporter()
+	dpi := resolution{x: float64(xDpi), y: float64(yDpi)}
+	if dpi.x <= 0 || dpi.y <= 0 {
+		dpi = resolution{x: float64(scanResolution), y: float64(scanResolution)}
+	}
+
+	for n, page := range pages {
+		switch strings.ToLower(filepath.Ext(page)) {
+		case ".pdf":
+			importPDF(pdf, importer, page)
+		case ".jpg", ".jpeg":
+			err = addJpegPage(pdf, page, dpi)
+		case ".png", ".tif", ".tiff":
+			err = addRasterPage(pdf, page, dpi, jpegQuality)
+		default:
+			err = fmt.Errorf("unsupported page format %s", page)
+		}
+		if err != nil {
+			return err
+		}
+		if n < len(texts) {
+			addTextLayer(pdf, texts[n])
+		}
+		if err := pdf.Error(); err != nil {
+			return fmt.Errorf("cant add %s: %w", page, err)
+		}
+	}
+
+	return pdf.OutputFileAndClose(pdfPath)
+}
+
+// resolution in DPI, X and Y may differ
+type resolution struct {
+	x, y float64
+}
+
+// pageSize returns the physical Size in pt of an Image of w x h
+// Pixels. The Resolution stored in the Image wins over dpi.
+func pageSize(file string, w int, h int, dpi resolution) gofpdf.SizeType {
+	if x, y, ok := imageDPI(file); ok {
+		dpi = resolution{x: x, y: y}
+	}
+	return gofpdf.SizeType{Wd: float64(w) * 72 / dpi.x, Ht: float64(h) * 72 / dpi.y}
+}
+
+// addJpegPage adds a Page showing the JPEG file. gofpdf embeds
+// the JPEG Data as is (DCTDecode).
+func addJpegPage(pdf *gofpdf.Fpdf, file string, dpi resolution) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("cant decode %s: %w", file, err)
+	}
+
+	size := pageSize(file, cfg.Width, cfg.Height, dpi)
+	pdf.AddPageFormat("P", size)
+	pdf.ImageOptions(file, 0, 0, size.Wd, size.Ht, false, gofpdf.ImageOptions{ImageType: "JPG"}, 0, "")
+	return nil
+}
+
+// addRasterPage adds a Page showing the PNG or TIFF file. Black
+// and white Images are compressed with CCITT G4, all others are
+// converted to JPEG of the given quality.
+func addRasterPage(pdf *gofpdf.Fpdf, file string, dpi resolution, quality int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("cant decode %s: %w", file, err)
+	}
+	size := pageSize(file, img.Bounds().Dx(), img.Bounds().Dy(), dpi)
+
+	if gray, ok := bilevel(img); ok {
+		addG4Page(pdf, gray, size)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	opts := gofpdf.ImageOptions{ImageType: "JPG"}
+	pdf.RegisterImageOptionsReader(file, opts, &buf)
+	pdf.AddPageFormat("P", size)
+	pdf.ImageOptions(file, 0, 0, size.Wd, size.Ht, false, opts, 0, "")
+	return nil
+}
+
+// addG4Page adds a Page of the given Size showing the bilevel
+// Image. gofpdf knows no CCITT Images, the Image XObject is passed
+// the way gofpdi passes imported Objects.
+func addG4Page(pdf *gofpdf.Fpdf, img *image.Gray, size gofpdf.SizeType) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	data := encodeG4(img)
+	obj := fmt.Sprintf("<</Type /XObject /Subtype /Image /Width %d /Height %d"+
+		" /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter /CCITTFaxDecode"+
+		" /DecodeParms <</K -1 /Columns %d /Rows %d>> /Length %d>>\nstream\n%s\nendstream\nendobj",
+		w, h, w, h, len(data), data)
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(obj)))
+	name := "/G4" + hash[:16]
+
+	pdf.ImportObjects(map[string][]byte{hash: []byte(obj)})
+	pdf.ImportTemplates(map[string]string{name: hash})
+	pdf.AddPageFormat("P", size)
+	// the Image fills the Unit Square, scaled to the Page
+	pdf.UseImportedTemplate(name, size.Wd, size.Ht, 0, -size.Ht)
+}
+
+// importPDF adds all Pages of the PDF file, keeping the Page
+// Sizes of the Device
+func importPDF(pdf *gofpdf.Fpdf, importer *gofpdi.Importer, file string) {
+	tpl := importer.ImportPage(pdf, file, 1, "/MediaBox")
+	// Page Sizes of the File imported last
+	sizes := importer.GetPageSizes()
+	for n := 1; n <= len(sizes); n++ {
+		if n > 1 {
+			tpl = importer.ImportPage(pdf, file, n, "/MediaBox")
+		}
+		w, h := sizes[n]["/MediaBox"]["w"], sizes[n]["/MediaBox"]["h"]
+		pdf.AddPageFormat("P", gofpdf.SizeType{Wd: w, Ht: h})
+		importer.UseImportedTemplate(pdf, tpl, 0, 0, w, h)
+	}
+}
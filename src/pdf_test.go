@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+	"golang.org/x/image/tiff"
+)
+
+// writeTestPDF writes a PDF with the given amount of A5 pages
+func writeTestPDF(t *testing.T, file string, pages int) {
+	pdf := gofpdf.New("P", "pt", "A5", "")
+	pdf.SetFont("Helvetica", "", 12)
+	for n := 1; n <= pages; n++ {
+		pdf.AddPage()
+		pdf.Text(40, 40, filepath.Base(file))
+	}
+	if err := pdf.OutputFileAndClose(file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPagesToPDFAcceptsMixedFormats(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "0001.pdf"), filepath.Join(dir, "0002.pdf")
+	writeTestPDF(t, first, 1)
+	writeTestPDF(t, second, 2)
+
+	img := image.NewGray(image.Rect(0, 0, 200, 300))
+	jpg, tif := filepath.Join(dir, "0003.jpg"), filepath.Join(dir, "0004.tiff")
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jpg, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tif, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	merged := filepath.Join(dir, "merged.pdf")
+	if err := pagesToPDF([]string{first, second, jpg, tif}, nil, 300, 150, 75, merged); err != nil {
+		t.Fatal(err)
+	}
+
+	importer := gofpdi.NewImporter()
+	importer.ImportPage(gofpdf.New("P", "pt", "A4", ""), merged, 1, "/MediaBox")
+	sizes := importer.GetPageSizes()
+	if len(sizes) != 5 {
+		t.Fatalf("expected 5 pages, got %d", len(sizes))
+	}
+	if w := sizes[3]["/MediaBox"]["w"]; w < 420 || w > 421 {
+		t.Fatalf("expected A5 width, got %f", w)
+	}
+	// 200x300 Pixels at 300x150 dpi
+	if w, h := sizes[4]["/MediaBox"]["w"], sizes[4]["/MediaBox"]["h"]; w != 48 || h != 144 {
+		t.Fatalf("expected 48x144pt JPEG page, got %fx%f", w, h)
+	}
+
+	// the JPEG is embedded without recompression
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("/DCTDecode")) {
+		t.Fatal("JPEG not embedded as DCTDecode")
+	}
+
+	if err := pagesToPDF([]string{filepath.Join(dir, "missing.pdf")}, nil, 0, 0, 75, merged); err == nil {
+		t.Fatal("expected missing PDF to fail")
+	}
+}
+
+func TestPagesToPDFPrefersImageResolution(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1654, 2339))); err != nil {
+		t.Fatal(err)
+	}
+	// insert a pHYs Chunk of 200 dpi (7874 Pixels per meter)
+	// behind the IHDR Chunk
+	phys := make([]byte, 9)
+	binary.BigEndian.PutUint32(phys[0:4], 7874)
+	binary.BigEndian.PutUint32(phys[4:8], 7874)
+	phys[8] = 1
+	chunk := binary.BigEndian.AppendUint32(nil, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = append(chunk, phys...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	data := buf.Bytes()
+	ihdrEnd := 8 + 12 + 13
+	data = append(data[:ihdrEnd:ihdrEnd], append(chunk, data[ihdrEnd:]...)...)
+
+	file := filepath.Join(dir, "0001.png")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if x, y, ok := imageDPI(file); !ok || x < 199.9 || x > 200.1 || x != y {
+		t.Fatalf("expected 200 dpi, got %f x %f", x, y)
+	}
+
+	merged := filepath.Join(dir, "merged.pdf")
+	if err := pagesToPDF([]string{file}, nil, 600, 600, 75, merged); err != nil {
+		t.Fatal(err)
+	}
+	importer := gofpdi.NewImporter()
+	importer.ImportPage(gofpdf.New("P", "pt", "A4", ""), merged, 1, "/MediaBox")
+	size := importer.GetPageSizes()[1]["/MediaBox"]
+	// A4 is 595x842pt
+	if size["w"] < 594 || size["w"] > 596 || size["h"] < 841 || size["h"] > 843 {
+		t.Fatalf("expected A4 page, got %fx%f", size["w"], size["h"])
+	}
+}
+
+func TestJfifDPI(t *testing.T) {
+	app0 := func(units byte, x, y uint16) []byte {
+		seg := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, units}
+		seg = binary.BigEndian.AppendUint16(seg, x)
+		seg = binary.BigEndian.AppendUint16(seg, y)
+		return append(seg, 0, 0)
+	}
+	if x, y, ok := jfifDPI(app0(1, 300, 150)); !ok || x != 300 || y != 150 {
+		t.Fatalf("expected 300x150 dpi, got %f x %f", x, y)
+	}
+	if x, _, ok := jfifDPI(app0(2, 100, 100)); !ok || x != 254 {
+		t.Fatalf("expected 254 dpi, got %f", x)
+	}
+	// Aspect Ratio only
+	if _, _, ok := jfifDPI(app0(0, 1, 1)); ok {
+		t.Fatal("expected no resolution")
+	}
+}
+
+func TestPagesToPDFCompressesByColor(t *testing.T) {
+	dir := t.TempDir()
+	bw, gray := image.NewGray(image.Rect(0, 0, 400, 400)), image.NewGray(image.Rect(0, 0, 400, 400))
+	for n := range bw.Pix {
+		if n%7 == 0 {
+			bw.Pix[n] = 0xff
+		}
+		gray.Pix[n] = uint8(n)
+	}
+
+	write := func(name string, img image.Image) string {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	merged := filepath.Join(dir, "bw.pdf")
+	if err := pagesToPDF([]string{write("0001.png", bw)}, nil, 0, 0, 75, merged); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("/CCITTFaxDecode")) {
+		t.Fatal("black and white page not compressed with CCITT G4")
+	}
+
+	// gray Pages are JPEGs of the given quality
+	size := func(quality int) int {
+		merged := filepath.Join(dir, "gray.pdf")
+		if err := pagesToPDF([]string{write("0002.png", gray)}, nil, 0, 0, quality, merged); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("/DCTDecode")) {
+			t.Fatal("gray page not compressed as JPEG")
+		}
+		return len(data)
+	}
+	if low, high := size(10), size(95); low >= high {
+		t.Fatalf("expected quality 10 to be smaller than 95, got %d and %d", low, high)
+	}
+}
+
+func TestPageExtSniffsContent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	for page, expected := range map[*ScanPage]string{
+		{ContentType: "image/jpeg; charset=binary"}: ".jpg",
+		{ContentType: "", Data: buf.Bytes()}: ".png",
+		{ContentType: "application/octet-stream", Data: []byte("%PDF-1.7\n")}: ".pdf",
+		{ContentType: "application/octet-stream", Data: []byte("II*\x00\x08\x00")}: ".tiff",
+		{ContentType: "", Data: []byte("garbage")}: ".bin",
+	} {
+		if ext := pageExt(page); ext != expected {
+			t.Fatalf("expected %s for %q, got %s", expected, page.ContentType, ext)
+		}
+	}
+}
+
+func TestBwScansAreCompressedWithG4(t *testing.T) {
+	dev := &ScanDevice{Cs: []ColorMode{ColorModeBw}, Pdl: []string{"image/jpeg", "image/png"}}
+	dto, err := dev.DefaultSettings("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.ColorMode != ColorModeBw || dto.DocumentFormat != "image/png" {
+		t.Fatalf("expected bw PNG, got %s %s", dto.ColorMode, dto.DocumentFormat)
+	}
+
+	// the Device delivers what the dto asks for
+	bw := image.NewGray(image.Rect(0, 0, 200, 200))
+	for n := range bw.Pix {
+		if n%3 == 0 {
+			bw.Pix[n] = 0xff
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, bw); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	page := filepath.Join(dir, "0001"+pageExt(&ScanPage{ContentType: dto.DocumentFormat, Data: buf.Bytes()}))
+	if err := os.WriteFile(page, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	merged := filepath.Join(dir, "merged.pdf")
+	if err := pagesToPDF([]string{page}, nil, dto.XResolution, dto.YResolution, 75, merged); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("/CCITTFaxDecode")) || bytes.Contains(data, []byte("/DCTDecode")) {
+		t.Fatal("black and white page not compressed with CCITT G4")
+	}
+
+	// bw requested of a color Device
+	dev.Cs = []ColorMode{ColorModeColor, ColorModeBw}
+	dto, _ = dev.DefaultSettings("", "")
+	if err := applyOverrides(dto, &jobRequest{Mode: ColorModeBw}, dev); err != nil {
+		t.Fatal(err)
+	}
+	if dto.DocumentFormat != "image/png" {
+		t.Fatalf("expected PNG for bw, got %s", dto.DocumentFormat)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"net"
 	"io"
 	"net/http"
+	"net/url"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ScanSettingsDto is a shorthand sibling to scanSettings.
@@ -19,7 +22,7 @@ import (
 type ScanSettingsDto struct {
 	Version string
 	DocumentFormat string
-	ColorMode  string
+	ColorMode  ColorMode
 	InputSource string
 	XResolution int
 	YResolution int
@@ -28,6 +31,31 @@ type ScanSettingsDto struct {
 	Width int
 	XOffset int
 	YOffset int
+	// scan both Sides, requires the adf InputSource
+	Duplex bool
+	// scan:Intent, lets the Device choose Compression and Filters
+	// suiting the Content. Optional, see scanIntents.
+	Intent string
+	// tesseract Language the Text is recognized in, e.g. deu,
+	// no OCR if empty. See ScanDevice.ocrsOnDevice.
+	OcrLanguage string
+}
+
+// scan:Intent values of the eSCL-Spec
+var scanIntents = []string{"Document", "TextAndGraphic", "Photo", "Preview", "Object", "BusinessCard"}
+
+// isDuplex reports whether both Sides are scanned, either
+// by the Duplex flag or the adf-duplex InputSource
+func (dto *ScanSettingsDto) isDuplex() bool {
+	return dto.Duplex || dto.InputSource == "adf-duplex"
+}
+
+// sourceKey is the Key of the InputSource in ScanDevice.Sources
+func (dto *ScanSettingsDto) sourceKey() string {
+	if dto.isDuplex() {
+		return "adf-duplex"
+	}
+	return dto.InputSource
 }
 
 // ScanDevice is modeled against the 
@@ -35,8 +63,22 @@ type ScanSettingsDto struct {
 // The eSCL Spec introduces the "Cs", "Is", "Pdl" ... 
 // Props
 type ScanDevice struct {
+	// unique Identifier of the Device, e.g. its UUID
+	Id string `json:"id"`
+	// Backend the Device is operated by, "escl" (default) or "sane"
+	Backend string `json:"backend"`
+	// SANE device-name, only used by the "sane" Backend
+	SaneDevice string `json:"sane_device,omitempty"`
 	// Host machine IPv4 address
 	AddrIPv4 net.IP `json:"IPv4"`
+	// Host machine IPv6 address, e.g. fe80::1%eth0, or hostname,
+	// used if the Device has no IPv4 address
+	Host string `json:"host,omitempty"`
+	// Root of the eSCL-Interface, e.g. http://192.168.0.157:8080/eSCL.
+	// Defaults to http://{IPv4 or host}/eSCL
+	URL string `json:"url,omitempty"`
+	// Trust of the Device Certificate if the url is https
+	Tls *TlsConfig `json:"tls,omitempty"`
 	// only mandatory element. SHOULD be “2.0” or later versions
 	Version string `json:"version"`
 	// human-readable make and model
@@ -44,11 +86,9 @@ type ScanDevice struct {
 	// URL to a PNG or ICO file containing a graphical
 	// representation of the scanner.
 	Representation string `json:"representation"`
-	// The ColorSpace defines the color capabilities of the scanner:
-	// "color" if the Scanner supports color scanning, "grayscale" if
-	// the scanner supports grayscale, "binary" if the scanner
-	// supports 1-bit monochrome scanning.
-	Cs []string `json:"color_spaces"`
+	// ColorModes supported by the Device: "color", "gray" and "bw",
+	// see ColorMode. Backend specific Names are translated.
+	Cs []ColorMode `json:"color_spaces"`
 	// The InputSource defines the list of scan input options:
 	// "platen" for glass flat bed scanning, "adf" for Automatic
 	// Document Feeder, "camera" if the Scanner has a non-
@@ -57,19 +97,55 @@ type ScanDevice struct {
 	// List of MIME media types supported by the scanner
 	// application/pdf,image/jpeg
 	Pdl []string
+	// Limits by InputSource as reported by the ScannerCapabilities,
+	// Devices without are not checked against Limits
+	Sources map[string]*InputSourceCaps `json:"sources,omitempty"`
+	// whether the Device delivers searchable PDFs (OCRSupport)
+	OCR bool `json:"ocr"`
+	// Languages of the Device OCR, e.g. en or de-DE
+	OCRLanguages []string `json:"ocr_languages,omitempty"`
+	// client used to talk to the eSCL-Device
+	client *http.Client
+}
+
+// ScanJob is a Job enqueued on the eSCL-Device by NewScanJob.
+// Its Documents are fetched via NextDocument until the
+// Device signals there are no more.
+type ScanJob struct {
+	device *ScanDevice
+	// absolute Job-URI as returned in the Location Header
+	Uri string
+	// how long NextDocument waits for the Device to scan
+	// a Page, defaultPageTimeout if zero
+	Timeout time.Duration
+}
+
+// ScanPage is a single Document returned by the Device,
+// e.g. one scanned Sheet of the ADF
+type ScanPage struct {
+	// MIME media type of Data, e.g. image/jpeg
+	ContentType string
+	Data []byte
 }
 
 // NewScanDevice creates a ScanDevice by querying the 
 // Scan Capabilities Interface of the eSCL-Device 
 // as specified in Chapter 8.2 in the MopriaSCANT-Spec V.2.97 
-// in order to fetch ScanDevice Capabillities
-func NewScanDevice(c *http.Client, deviceIP *net.IP) (*ScanDevice, error) {
-	url := fmt.Sprintf("http://%s/eSCL/ScannerCapabilities", deviceIP.String())
-	resp, err := c.Get(url)
+// in order to fetch ScanDevice Capabillities. baseURL is the
+// Root of the eSCL-Interface, e.g. http://192.168.0.157/eSCL
+func NewScanDevice(c *http.Client, baseURL string) (*ScanDevice, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Get(base.JoinPath("ScannerCapabilities").String())
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ScannerCapabilities failed: Status: %d - %s", resp.StatusCode, resp.Status)
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return  nil, err
@@ -79,43 +155,153 @@ func NewScanDevice(c *http.Client, deviceIP *net.IP) (*ScanDevice, error) {
 		return nil, err
 	}
 
-	colorModes := []string{}
+	colorModes := []ColorMode{}
 	mimeTypes := []string{}
 	for _, profile := range caps.SettingProfiles.Profiles {
-		colorModes = append(colorModes, profile.ColorModes...)
+		colorModes = appendMissing(colorModes, normalizeColorModes(profile.ColorModes)...)
 		mimeTypes = append(mimeTypes, profile.DocumentFormats.DocumentFormat...)
 	}
 
 	inputSource := []string{}
+	sources := map[string]*InputSourceCaps{}
 	if caps.Platen != nil {
 		inputSource = append(inputSource, "platen")
+		sources["platen"] = caps.Platen.InputCaps.sourceCaps(caps.SettingProfiles)
 	}
 	if caps.Adf != nil {
 		inputSource = append(inputSource, "adf")
+		sources["adf"] = caps.Adf.SimplexInputCaps.sourceCaps(caps.SettingProfiles)
+		if caps.Adf.SupportsDuplex() {
+			inputSource = append(inputSource, "adf-duplex")
+			sources["adf-duplex"] = sources["adf"]
+			if caps.Adf.DuplexInputCaps != nil {
+				sources["adf-duplex"] = caps.Adf.DuplexInputCaps.sourceCaps(caps.SettingProfiles)
+			}
+		}
 	}
 	
-	return &ScanDevice{
-		AddrIPv4: *deviceIP,
+	// some Devices list their Profiles per InputSource only
+	for _, is := range inputSource {
+		colorModes = appendMissing(colorModes, sources[is].ColorModes...)
+		mimeTypes = appendMissing(mimeTypes, sources[is].DocumentFormats...)
+	}
+
+	sd := &ScanDevice{
+		Id: caps.UUID,
+		Backend: BackendEscl,
+		URL: base.String(),
 		Version: caps.Version,
 		Ty: caps.MakeAndModel,
 		Representation: caps.IconURI,
 		Cs: colorModes,
 		Is: inputSource,
 		Pdl: mimeTypes,
-	}, nil
+		Sources: sources,
+		OCR: caps.OCRSupport,
+		OCRLanguages: caps.OCRLanguageSupport.Languages,
+		client: c,
+	}
+	if ip := net.ParseIP(base.Hostname()); ip.To4() != nil {
+		sd.AddrIPv4 = ip
+	} else {
+		sd.Host = base.Hostname()
+	}
+	return sd, nil
+}
+
+// sameDevice reports whether both Devices denote the same
+// physical Device, e.g. a configured and a discovered one
+func (sd *ScanDevice) sameDevice(other *ScanDevice) bool {
+	if sd.Id != "" && sd.Id == other.Id {
+		return true
+	}
+	base, err := sd.baseURL()
+	if err != nil {
+		return false
+	}
+	otherBase, err := other.baseURL()
+	if err != nil {
+		return false
+	}
+	return base.Hostname() == otherBase.Hostname()
+}
+
+// initClient prepares the Client of Devices reachable via HTTPS,
+// all others use the http.DefaultClient
+func (sd *ScanDevice) initClient(ts *TrustStore) error {
+	if !sd.isTls() {
+		return nil
+	}
+	client, err := newTlsClient(sd, ts)
+	if err != nil {
+		return err
+	}
+	sd.client = client
+	return nil
+}
+
+// isTls reports whether the eSCL-Interface is served via HTTPS
+func (sd *ScanDevice) isTls() bool {
+	base, err := sd.baseURL()
+	return err == nil && base.Scheme == "https"
+}
+
+// httpClient returns the Client the Device was created with or
+// the http.DefaultClient for plain HTTP Devices
+func (sd *ScanDevice) httpClient() *http.Client {
+	if sd.client != nil {
+		return sd.client
+	}
+	return http.DefaultClient
+}
+
+// hostname returns the IPv4 address of the Device, if any,
+// or its Host otherwise
+func (sd *ScanDevice) hostname() string {
+	if sd.AddrIPv4 != nil {
+		return sd.AddrIPv4.String()
+	}
+	return strings.Trim(sd.Host, "[]")
+}
+
+// baseURL returns the Root of the eSCL-Interface
+func (sd *ScanDevice) baseURL() (*url.URL, error) {
+	if sd.URL != "" {
+		return url.Parse(sd.URL)
+	}
+	host := sd.hostname()
+	if host == "" {
+		return nil, fmt.Errorf("Device %s has neither url, IPv4 nor host", sd.Id)
+	}
+	return &url.URL{Scheme: "http", Host: urlHost(host, 0), Path: "/eSCL"}, nil
 }
 
-// NewScanJob advices the Scanner to enqueue a new Scan-Job
-func (sd *ScanDevice) NewScanJob(dto *ScanSettingsDto) error {
+// urlHost formats host and port for the Host of an URL. IPv6
+// addresses are enclosed in brackets, port 0 is omitted.
+func urlHost(host string, port int) string {
+	if port != 0 {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// NewScanJob advices the Scanner to enqueue a new Scan-Job.
+// The scanned Documents are fetched by the returned ScanJob,
+// see 11.5 Usage Flow on Page 54
+func (sd *ScanDevice) NewScanJob(dto *ScanSettingsDto) (*ScanJob, error) {
 	
 	if err := sd.Validate(dto); err != nil {
-		return err
+		return nil, err
 	}
 
 	settings := scanSettings{
 		XmlnsPwg:  "http://www.pwg.org/schemas/2010/12/sm",
 		XmlnsScan: "http://schemas.hp.com/imaging/escl/2011/05/03",
 		Version: dto.Version,	
+		Intent: dto.Intent,
 
 		ScanRegions: scanRegions{
 			ScanRegion: scanRegion{
@@ -126,66 +312,196 @@ func (sd *ScanDevice) NewScanJob(dto *ScanSettingsDto) error {
 				YOffset: dto.YOffset,
 			},
 		},
-		ColorMode: dto.ColorMode,
+		ColorMode: dto.ColorMode.escl(),
 		XResolution: dto.XResolution,
 		YResolution: dto.YResolution,
-		InputSource: dto.InputSource,
+		InputSource: esclInputSource(dto.InputSource),
 		DocumentFormatExt: &documentFormatExt{
 			DocumentFormat: dto.DocumentFormat,
 		},
 	}
+	if sd.ocrsOnDevice(dto) {
+		settings.OCRLanguage, _ = sd.ocrLanguage(dto.OcrLanguage)
+	}
+	// scan:Duplex is only meaningful for the Feeder
+	if esclInputSource(dto.InputSource) == "Feeder" {
+		duplex := dto.isDuplex()
+		settings.Duplex = &duplex
+	}
 
 	buf, _ := xml.MarshalIndent(settings, "", "  ")
-	url := fmt.Sprintf("http://%s/eSCL/ScanJobs", sd.AddrIPv4.String())
-	resp, err := http.Post(url, "application/xml", bytes.NewReader(buf))
+	base, err := sd.baseURL()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	endpoint := base.JoinPath("ScanJobs")
+	resp, err := sd.httpClient().Post(endpoint.String(), "application/xml", bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
 	}
 	resp.Body.Close()
 	
-	if resp.StatusCode != 201 {
-		return fmt.Errorf("Scan failed: Status: %d - %s", resp.StatusCode, resp.Status)
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("Scan failed: Status: %d - %s", resp.StatusCode, resp.Status)
 	} 
 
-	jobUri := resp.Header.Get("Location")
-	if jobUri == "" {
-		return fmt.Errorf("Scan failed: No Location was returned!")
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, fmt.Errorf("Scan failed: No Location was returned!")
 	}
 
-	// see 11.5 Usage Flow on Page 54
-	// TBD
+	// the Location may be absolute or relative to the Device
+	jobUri, err := endpoint.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Scan failed: invalid Location %q: %w", location, err)
+	}
 
-	return nil
+	return &ScanJob{device: sd, Uri: jobUri.String()}, nil
+}
+
+// how long NextDocument waits for a Page while the Device is
+// busy, ADF and high Resolution Scans take a while
+const defaultPageTimeout = 5 * time.Minute
+
+// NextDocument fetches the next scanned Document of the Job.
+// io.EOF is returned once the Device answers with 404,
+// which signals that all Documents have been transferred.
+func (job *ScanJob) NextDocument() (*ScanPage, error) {
+
+	nextDocument := strings.TrimSuffix(job.Uri, "/") + "/NextDocument"
+	timeout := job.Timeout
+	if timeout <= 0 {
+		timeout = defaultPageTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		resp, err := job.device.httpClient().Get(nextDocument)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			return &ScanPage{
+				ContentType: resp.Header.Get("Content-Type"),
+				Data: data,
+			}, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return nil, io.EOF
+		case http.StatusServiceUnavailable:
+			// the Device is still scanning, try again later
+			resp.Body.Close()
+			wait := retryAfter(resp)
+			if time.Now().Add(wait).After(deadline) {
+				return nil, fmt.Errorf("NextDocument failed: no page within %s", timeout)
+			}
+			time.Sleep(wait)
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("NextDocument failed: Status: %d - %s", resp.StatusCode, resp.Status)
+		}
+	}
+}
+
+// Pages fetches all Documents of the Job
+func (job *ScanJob) Pages() ([]*ScanPage, error) {
+	pages := []*ScanPage{}
+	for {
+		page, err := job.NextDocument()
+		if err == io.EOF {
+			return pages, nil
+		}
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+	}
+}
+
+// retryAfter honors the Retry-After Header of a 503 Response
+func retryAfter(resp *http.Response) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return time.Second
+}
+
+// esclInputSource maps the InputSource of the ScanDevice
+// ("platen", "adf", "adf-duplex", "camera") to the
+// pwg:InputSource Keyword
+func esclInputSource(is string) string {
+	switch is {
+	case "platen":
+		return "Platen"
+	case "adf", "adf-duplex":
+		return "Feeder"
+	case "camera":
+		return "Camera"
+	}
+	return is
+}
+
+// documentFormats returns the MIME types the InputSource given by
+// its sourceKey delivers, those of the Device if it has no Caps
+func (sd *ScanDevice) documentFormats(sourceKey string) []string {
+	if caps := sd.Sources[sourceKey]; caps != nil {
+		return caps.DocumentFormats
+	}
+	return sd.Pdl
 }
 
 // internal indicator, whether Scanner supports PDF generation
-func (sd *ScanDevice) isPdfSupported() bool {
-	return slices.Contains(sd.Pdl, "application/pdf")
+// for the InputSource given by its sourceKey
+func (sd *ScanDevice) isPdfSupported(sourceKey string) bool {
+	return slices.Contains(sd.documentFormats(sourceKey), "application/pdf")
 }
 
 // Validate validates the dto against ScanDevice configuration
+// and the Limits of the InputSource. All invalid Fields are
+// reported as ValidationErrors.
 func (sd *ScanDevice) Validate(dto *ScanSettingsDto) error {
 
-	if !slices.Contains(sd.Cs, dto.ColorMode) {
-		return fmt.Errorf(
-			"unsupported ColorMode %s, supported are: %s", 
-			dto.ColorMode, 
-			strings.Join(sd.Cs, ","),
-		)
-	}
+	var errs ValidationErrors
 
 	if !slices.Contains(sd.Is, dto.InputSource) {
-		return fmt.Errorf(
-			"unsupported InputSource %s, supported are: %s", 
-			dto.InputSource, 
-			strings.Join(sd.Is, ","),
-		)
+		errs.add("InputSource", "unsupported InputSource %s, supported are: %s", dto.InputSource, strings.Join(sd.Is, ","))
+	}
+
+	if dto.Duplex && dto.InputSource != "adf" && dto.InputSource != "adf-duplex" {
+		errs.add("Duplex", "Duplex requires the adf InputSource, got %s", dto.InputSource)
+	} else if dto.isDuplex() && !slices.Contains(sd.Is, "adf-duplex") {
+		errs.add("Duplex", "Duplex is not supported by the Device")
+	}
+
+	if dto.Intent != "" && !slices.Contains(scanIntents, dto.Intent) {
+		errs.add("Intent", "unknown Intent %s, known are: %s", dto.Intent, strings.Join(scanIntents, ","))
 	}
 
 	if dto.Version != sd.Version {
-		return  fmt.Errorf("Given Version %s dont matched support Version %s", dto.Version, sd.Version)
+		errs.add("Version", "Given Version %s dont matched support Version %s", dto.Version, sd.Version)
 	}
 
+	if caps, ok := sd.Sources[dto.sourceKey()]; ok {
+		errs = append(errs, caps.validate(dto)...)
+	} else {
+		if !slices.Contains(sd.Cs, dto.ColorMode) {
+			errs.add("ColorMode", "unsupported ColorMode %s, supported are: %s", dto.ColorMode, joinColorModes(sd.Cs))
+		}
+		if len(sd.Pdl) > 0 && !slices.Contains(sd.Pdl, dto.DocumentFormat) {
+			errs.add("DocumentFormat", "unsupported DocumentFormat %s, supported are: %s", dto.DocumentFormat, strings.Join(sd.Pdl, ","))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
@@ -194,13 +510,17 @@ type scanSettings struct {
 	XmlnsPwg  string `xml:"xmlns:pwg,attr"`
 	XmlnsScan string `xml:"xmlns:scan,attr"`
 	Version string `xml:"pwg:Version"`
+	Intent string `xml:"scan:Intent,omitempty"`
 	ScanRegions scanRegions `xml:"pwg:ScanRegions"`
 	ColorMode  string `xml:"scan:ColorMode"`
 	XResolution int `xml:"scan:XResolution"`
 	YResolution int `xml:"scan:YResolution"`
 	InputSource string `xml:"pwg:InputSource"`
 	DocumentFormatExt *documentFormatExt `xml:"scan:DocumentFormatExt,omitempty"`
+	Duplex *bool `xml:"scan:Duplex,omitempty"`
 	CompressionFactor *int `xml:"scan:CompressionFactor,omitempty"`
+	// Language of the Text Layer of searchable PDFs
+	OCRLanguage string `xml:"scan:OCRLanguage,omitempty"`
 }
 
 type scanRegions struct {
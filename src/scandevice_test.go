@@ -1,31 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"path"
-	"net"
 	"net/http"
-	"net/url"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCanCreateConfigByDto(t *testing.T) {
 	dev := &ScanDevice{
 		Version: "2.0",
-		Cs: []string{"grey"},
+		Cs: []ColorMode{ColorModeGray},
 		Is: []string{"adf"},
-		Pdl: []string{"application/pdf"},
+		Pdl: []string{"application/pdf", "image/jpeg"},
 	}
 
-	if !dev.isPdfSupported() {
+	if !dev.isPdfSupported("") {
 		t.Fatalf("PDF is not supported MIME-Type, but should be!")
 	}
 
-	err := dev.NewScanJob(&ScanSettingsDto{
+	server := newFakeScanner(t, 3)
+	defer server.Close()
+	useTestServer(dev, server)
+
+	job, err := dev.NewScanJob(&ScanSettingsDto{
 		Version: "2.0",
-		DocumentFormat: "pdf",
-		ColorMode: "grey",
+		DocumentFormat: "image/jpeg",
+		ColorMode: ColorModeGray,
 		InputSource: "adf",
 		XResolution: 300,
 		YResolution: 300,
@@ -37,19 +49,64 @@ func TestCanCreateConfigByDto(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	pages, err := job.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if pages[0].ContentType != "image/jpeg" {
+		t.Fatalf("unexpected ContentType %s", pages[0].ContentType)
+	}
+}
+
+func TestNextDocumentWaitsForSlowPages(t *testing.T) {
+	// the Device is busy scanning until ready is reached
+	var ready atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if time.Now().UnixNano() < ready.Load() {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		fmt.Fprint(w, "page")
+	}))
+	defer server.Close()
+
+	dev := &ScanDevice{}
+	useTestServer(dev, server)
+	job := &ScanJob{device: dev, Uri: server.URL + "/eSCL/ScanJobs/1", Timeout: 3 * time.Second}
+
+	ready.Store(time.Now().Add(1500 * time.Millisecond).UnixNano())
+	if _, err := job.NextDocument(); err != nil {
+		t.Fatal(err)
+	}
+
+	ready.Store(time.Now().Add(time.Hour).UnixNano())
+	job.Timeout = 1500 * time.Millisecond
+	start := time.Now()
+	if _, err := job.NextDocument(); err == nil {
+		t.Fatal("expected busy device to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("timeout of %s exceeded: %s", job.Timeout, elapsed)
+	}
 }
 
 func TestCantCreateConfigByInvalidDto(t *testing.T) {
 	dev := &ScanDevice{
 		Version: "2.0",
-		Cs: []string{"color"},
+		Cs: []ColorMode{ColorModeColor},
 		Is: []string{"platen"},
 		Pdl: []string{"image/jpeg"},
 	}
-	err := dev.NewScanJob(&ScanSettingsDto{
+	_, err := dev.NewScanJob(&ScanSettingsDto{
 		Version: "2.0",
 		DocumentFormat: "pdf",
-		ColorMode: "grey",
+		ColorMode: ColorModeGray,
 		InputSource: "adf",
 	})
 	if err == nil {
@@ -73,21 +130,342 @@ func TestCanFetchCapabilitiesAndCreateDevice(t *testing.T) {
 	}))
 	defer server.Close()
 
-	// Server-URL -> IP extrahieren
-	u, err := url.Parse(server.URL)
+	dev, err := NewScanDevice(server.Client(), server.URL+"/eSCL")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	host, _, _ := net.SplitHostPort(u.Host)
-	ip := net.ParseIP(host)
+	if dev == nil {
+		t.Fatal("device is nil")
+	}
+
+	if dev.URL != server.URL+"/eSCL" || dev.AddrIPv4.String() != "127.0.0.1" {
+		t.Fatalf("unexpected device address %s, %s", dev.URL, dev.AddrIPv4)
+	}
+}
+
+// newFakeScanner emulates the eSCL Scan-Job Interface
+// of a Device holding the given amount of pages in its ADF
+func newFakeScanner(t *testing.T, pages int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/eSCL/ScanJobs":
+			w.Header().Set("Location", "/eSCL/ScanJobs/1")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && r.URL.Path == "/eSCL/ScanJobs/1/NextDocument":
+			if pages == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			pages--
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprintf(w, "page %d", pages)
+		default:
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+// useTestServer points the device to the test server
+func useTestServer(dev *ScanDevice, server *httptest.Server) {
+	dev.URL = server.URL + "/eSCL"
+}
 
-	dev, err := NewScanDevice(server.Client(), &ip)
+// newTestDevice creates a ScanDevice from the ScannerCapabilities
+// in the given testdata file
+func newTestDevice(t *testing.T, caps string) *ScanDevice {
+	data, err := os.ReadFile(path.Join("testdata", caps))
 	if err != nil {
 		t.Fatal(err)
 	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
 
-	if dev == nil {
-		t.Fatal("device is nil")
+	dev, err := NewScanDevice(server.Client(), server.URL+"/eSCL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func TestCanFetchStatus(t *testing.T) {
+
+	scannerStatusXML, err := os.ReadFile(path.Join("testdata/status.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eSCL/ScannerStatus" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(scannerStatusXML)
+	}))
+	defer server.Close()
+
+	dev := &ScanDevice{}
+	useTestServer(dev, server)
+
+	status, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != ScannerStateIdle || status.IsBusy() {
+		t.Fatalf("unexpected State %s", status.State)
+	}
+	if !status.IsAdfJammed() {
+		t.Fatalf("ADF should be jammed, AdfState is %s", status.AdfState)
+	}
+	if len(status.Jobs) != 1 || status.Jobs[0].JobStateReasons[0] != "AbortedBySystem" {
+		t.Fatalf("unexpected Jobs %+v", status.Jobs)
+	}
+}
+
+func TestBaseURLOfIPv6AndHostname(t *testing.T) {
+	for host, expected := range map[string]string{
+		"fe80::1%eth0": "http://[fe80::1%25eth0]/eSCL",
+		"2001:db8::157": "http://[2001:db8::157]/eSCL",
+		"scanner.local": "http://scanner.local/eSCL",
+	} {
+		base, err := (&ScanDevice{Host: host}).baseURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base.String() != expected {
+			t.Fatalf("expected %s, got %s", expected, base)
+		}
+	}
+}
+
+func TestDuplexIsParsedAndSent(t *testing.T) {
+	var caps ScannerCapabilities
+	err := xml.Unmarshal([]byte(`<scan:ScannerCapabilities xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03">
+		<scan:Adf><scan:AdfOptions><scan:AdfOption>Duplex</scan:AdfOption></scan:AdfOptions></scan:Adf>
+	</scan:ScannerCapabilities>`), &caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Adf == nil || !caps.Adf.SupportsDuplex() {
+		t.Fatal("expected duplex support")
+	}
+
+	dev := &ScanDevice{Version: "2.0", Cs: []ColorMode{ColorModeColor}, Is: []string{"platen", "adf", "adf-duplex"}}
+	dto := &ScanSettingsDto{Version: "2.0", ColorMode: ColorModeColor, InputSource: "platen", Duplex: true}
+	if err := dev.Validate(dto); err == nil {
+		t.Fatal("expected duplex on platen to be rejected")
+	}
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Location", "/eSCL/ScanJobs/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	useTestServer(dev, server)
+
+	dto.InputSource = "adf"
+	if _, err := dev.NewScanJob(dto); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("<scan:Duplex>true</scan:Duplex>")) {
+		t.Fatalf("scan:Duplex missing in %s", body)
+	}
+}
+
+func TestValidateAgainstLimitsOfInputSource(t *testing.T) {
+	dev := newTestDevice(t, "caps.xml")
+
+	dto := &ScanSettingsDto{
+		Version: "2.63",
+		DocumentFormat: "image/jpeg",
+		ColorMode: ColorModeColor,
+		InputSource: "adf",
+		XResolution: 300,
+		YResolution: 300,
+		Width: 2480,
+		Height: 4000,
+	}
+	if err := dev.Validate(dto); err != nil {
+		t.Fatal(err)
+	}
+
+	// the platen is shorter than the ADF
+	dto.InputSource = "platen"
+	dto.XResolution = 150
+	err := dev.Validate(dto)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	if strings.Join(fields, ",") != "XResolution,Height,YOffset" {
+		t.Fatalf("unexpected invalid fields %v", errs)
+	}
+
+	// the Field failing is reported, the Combination if both Axes
+	// are supported on their own
+	dto.InputSource = "adf"
+	for _, tc := range []struct {
+		x, y int
+		field string
+	}{
+		{300, 150, "YResolution"},
+		{300, 200, "Resolution"},
+	} {
+		dto.XResolution, dto.YResolution = tc.x, tc.y
+		err = dev.Validate(dto)
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != tc.field {
+			t.Fatalf("expected invalid %s for %dx%d, got %v", tc.field, tc.x, tc.y, err)
+		}
+	}
+}
+
+func TestCapabilitiesPerInputSource(t *testing.T) {
+	dev := newTestDevice(t, "caps.xml")
+
+	cfg := &Config{Devices: []*ScanDevice{dev}}
+	dc := NewDevicesController(cfg, NewDeviceRegistry(cfg, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/devices/{id}/capabilities", dc.ServeCapabilities)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices/"+dev.Id+"/capabilities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	var caps deviceCapabilities
+	if err := json.NewDecoder(rec.Body).Decode(&caps); err != nil {
+		t.Fatal(err)
+	}
+	platen, adf := caps.Sources["platen"], caps.Sources["adf"]
+	if platen == nil || adf == nil {
+		t.Fatalf("missing input sources: %v", caps.Sources)
+	}
+	if platen.MaxHeight != 3507 || adf.MaxHeight != 4200 {
+		t.Fatalf("unexpected max heights %d, %d", platen.MaxHeight, adf.MaxHeight)
+	}
+	if platen.MaxOpticalXResolution != 600 || !slices.Contains(platen.Intents, "Photo") {
+		t.Fatalf("unexpected platen caps %+v", platen)
+	}
+	if !slices.Contains(adf.ColorModes, ColorModeGray) || len(adf.Resolutions) != 3 {
+		t.Fatalf("unexpected adf caps %+v", adf)
+	}
+}
+
+func TestDefaultSettingsAreValid(t *testing.T) {
+	dev := newTestDevice(t, "caps.xml")
+
+	dto, err := dev.DefaultSettings("adf", "Document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.ColorMode != ColorModeGray || dto.XResolution != 200 || dto.Width != 2550 || dto.Height != 4200 {
+		t.Fatalf("unexpected defaults %+v", dto)
+	}
+	// the Device produces PDF itself
+	if dto.DocumentFormat != "application/pdf" {
+		t.Fatalf("unexpected defaults %+v", dto)
+	}
+	if err := dev.Validate(dto); err != nil {
+		t.Fatal(err)
+	}
+
+	dto, err = dev.DefaultSettings("", "Photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.InputSource != "platen" || dto.ColorMode != ColorModeColor || dto.Height != 3507 {
+		t.Fatalf("unexpected defaults %+v", dto)
+	}
+
+	if _, err := dev.DefaultSettings("camera", ""); err == nil {
+		t.Fatal("expected unsupported source to fail")
+	}
+}
+
+func TestOverridesAreSnappedAndChecked(t *testing.T) {
+	dev := newTestDevice(t, "caps.xml")
+
+	dto, err := dev.DefaultSettings("adf", "Document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOverrides(dto, &jobRequest{Resolution: 280, Format: "image/jpeg"}, dev); err != nil {
+		t.Fatal(err)
+	}
+	if dto.XResolution != 300 || dto.YResolution != 300 {
+		t.Fatalf("expected closest resolution 300, got %dx%d", dto.XResolution, dto.YResolution)
+	}
+	if err := dev.Validate(dto); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyOverrides(dto, &jobRequest{Format: "text/plain"}, dev); err == nil {
+		t.Fatal("expected format scanbridge cant build PDFs from to fail")
+	}
+	dto.DocumentFormat = "image/tiff"
+	if err := dev.Validate(dto); err == nil {
+		t.Fatal("expected format unsupported by the device to fail")
+	}
+
+	// the Device delivers nothing PDFs can be built from
+	bmp := &ScanDevice{Is: []string{"platen"}, Cs: []ColorMode{ColorModeColor}, Pdl: []string{"image/bmp"}}
+	if _, err := bmp.DefaultSettings("platen", ""); err == nil {
+		t.Fatal("expected device without supported format to fail")
+	}
+}
+
+func TestIntentIsValidatedAndSent(t *testing.T) {
+	dev := newTestDevice(t, "caps.xml")
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Location", "/eSCL/ScanJobs/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	useTestServer(dev, server)
+
+	dto, err := dev.DefaultSettings("platen", "BusinessCard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Validate(dto); err == nil {
+		t.Fatal("expected intent unsupported by the platen to fail")
+	}
+	dto.Intent = "Snapshot"
+	if err := dev.Validate(dto); err == nil {
+		t.Fatal("expected unknown intent to fail")
+	}
+
+	dto.Intent = "Photo"
+	if _, err := dev.NewScanJob(dto); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("<scan:Intent>Photo</scan:Intent>")) {
+		t.Fatalf("scan:Intent missing in %s", body)
+	}
+}
+
+func TestClosestResolution(t *testing.T) {
+	discrete := &InputSourceCaps{Resolutions: []DiscreteResolution{{100, 100}, {300, 300}, {600, 600}}}
+	if x, y := discrete.closestResolution(400); x != 300 || y != 300 {
+		t.Fatalf("expected 300, got %dx%d", x, y)
+	}
+	ranged := &InputSourceCaps{ResolutionRange: &ResolutionRange{
+		XResolutionRange: ResolutionAxis{Min: 75, Max: 1200, Step: 25},
+		YResolutionRange: ResolutionAxis{Min: 75, Max: 1200, Step: 25},
+	}}
+	if x, y := ranged.closestResolution(210); x != 200 || y != 200 {
+		t.Fatalf("expected 200, got %dx%d", x, y)
+	}
+	if x, _ := ranged.closestResolution(2400); x != 1200 {
+		t.Fatalf("expected 1200, got %d", x)
 	}
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Scanner is the Backend a ScanDevice is operated by.
+// Scanbridge speaks eSCL natively and falls back to
+// SANE (scanimage) for all other Devices.
+type Scanner interface {
+	// Capabilities returns the Device the Scanner operates
+	Capabilities() *ScanDevice
+	// StartJob starts a new Scan with the given Settings
+	StartJob(dto *ScanSettingsDto) (ScannerJob, error)
+	// Status reports the current State of the Device
+	Status() (*ScannerStatus, error)
+	// Validate checks the Settings before the Job is queued,
+	// invalid Fields are reported as ValidationErrors
+	Validate(dto *ScanSettingsDto) error
+}
+
+// ScannerJob is a running Scan started by Scanner.StartJob
+type ScannerJob interface {
+	// NextPage returns the next scanned Page. io.EOF is
+	// returned once all Pages have been transferred.
+	NextPage() (*ScanPage, error)
+	// Cancel aborts the Job
+	Cancel() error
+}
+
+// Backends a ScanDevice can be configured with
+const (
+	BackendEscl string = "escl"
+	BackendSane string = "sane"
+)
+
+// NewScanner creates the Scanner-Backend of the given Device
+func NewScanner(sd *ScanDevice, cfg *Config) (Scanner, error) {
+	switch sd.Backend {
+	case "", BackendEscl:
+		return &esclScanner{device: sd, pageTimeout: cfg.pageTimeout()}, nil
+	case BackendSane:
+		if cfg.Scanimage == "" {
+			return nil, fmt.Errorf("scanimage binary not configured")
+		}
+		return &saneScanner{device: sd, bin: cfg.Scanimage}, nil
+	}
+	return nil, fmt.Errorf("unsupported Backend %s", sd.Backend)
+}
+
+/* ---------------- eSCL ---------------- */
+
+type esclScanner struct {
+	device *ScanDevice
+	pageTimeout time.Duration
+}
+
+func (s *esclScanner) Capabilities() *ScanDevice {
+	return s.device
+}
+
+func (s *esclScanner) StartJob(dto *ScanSettingsDto) (ScannerJob, error) {
+	job, err := s.device.NewScanJob(dto)
+	if err != nil {
+		return nil, err
+	}
+	job.Timeout = s.pageTimeout
+	return job, nil
+}
+
+func (s *esclScanner) Status() (*ScannerStatus, error) {
+	return s.device.Status()
+}
+
+func (s *esclScanner) Validate(dto *ScanSettingsDto) error {
+	return s.device.Validate(dto)
+}
+
+// NextPage implements ScannerJob
+func (job *ScanJob) NextPage() (*ScanPage, error) {
+	return job.NextDocument()
+}
+
+// Cancel deletes the Job on the Device
+func (job *ScanJob) Cancel() error {
+	req, err := http.NewRequest(http.MethodDelete, job.Uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := job.device.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 404: the Job is already gone
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Cancel failed: Status: %d - %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+/* ---------------- SANE ---------------- */
+
+// exit Status of scanimage once the ADF ran empty (SANE_STATUS_NO_DOCS)
+const saneStatusNoDocs = 7
+
+// saneScanner operates a Device via the scanimage binary
+type saneScanner struct {
+	device *ScanDevice
+	bin string
+	mu sync.Mutex
+	running *saneJob
+}
+
+func (s *saneScanner) Capabilities() *ScanDevice {
+	return s.device
+}
+
+// StartJob runs scanimage in batch mode. scanimage validates
+// the Options against the SANE-Backend itself.
+func (s *saneScanner) StartJob(dto *ScanSettingsDto) (ScannerJob, error) {
+
+	dir, err := os.MkdirTemp("", "scanbridge-sane*")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(
+		s.bin,
+		fmt.Sprintf("--device-name=%s", s.device.SaneDevice),
+		fmt.Sprintf("--source=%s", dto.InputSource),
+		fmt.Sprintf("--format=%s", scanFormat),
+		fmt.Sprintf("--resolution=%d", dto.XResolution),
+		fmt.Sprintf("--batch=%s/%%d.%s", dir, scanFormat),
+		fmt.Sprintf("--mode=%s", dto.ColorMode.sane()),
+		"--batch-start=1",
+	)
+
+	job := &saneJob{scanner: s, dir: dir, cmd: cmd, done: make(chan struct{})}
+	cmd.Stderr = &job.stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	// set before scanimage may have exited already
+	s.mu.Lock()
+	s.running = job
+	s.mu.Unlock()
+
+	go func() {
+		job.err = cmd.Wait()
+		s.mu.Lock()
+		if s.running == job {
+			s.running = nil
+		}
+		s.mu.Unlock()
+		close(job.done)
+	}()
+
+	return job, nil
+}
+
+// Status reports whether a scanimage batch is running,
+// SANE has no notion of the AdfState
+func (s *saneScanner) Status() (*ScannerStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil {
+		return &ScannerStatus{State: ScannerStateProcessing}, nil
+	}
+	return &ScannerStatus{State: ScannerStateIdle}, nil
+}
+
+// Validate accepts all Settings, scanimage validates
+// them against the SANE-Backend itself
+func (s *saneScanner) Validate(dto *ScanSettingsDto) error {
+	return nil
+}
+
+// saneJob is a running scanimage batch. Pages are handed out
+// as soon as scanimage has started writing the following one.
+type saneJob struct {
+	scanner *saneScanner
+	dir string
+	cmd *exec.Cmd
+	stderr bytes.Buffer
+	// closed once scanimage exited
+	done chan struct{}
+	err error
+	// number of pages handed out so far
+	pages int
+}
+
+func (job *saneJob) pagePath(n int) string {
+	return filepath.Join(job.dir, fmt.Sprintf("%d.%s", n, scanFormat))
+}
+
+func (job *saneJob) NextPage() (*ScanPage, error) {
+
+	current := job.pagePath(job.pages + 1)
+	next := job.pagePath(job.pages + 2)
+
+	for {
+		exited := false
+		select {
+		case <-job.done:
+			exited = true
+		default:
+		}
+
+		if _, err := os.Stat(current); err == nil {
+			_, nextErr := os.Stat(next)
+			if exited || nextErr == nil {
+				data, err := os.ReadFile(current)
+				if err != nil {
+					return nil, err
+				}
+				job.pages++
+				return &ScanPage{ContentType: "image/" + scanFormat, Data: data}, nil
+			}
+		} else if exited {
+			defer os.RemoveAll(job.dir)
+			// scanimage reports an empty feeder after the
+			// last page of a batch, that is not an error. Any
+			// other failure would truncate the Document.
+			var exitErr *exec.ExitError
+			if job.err != nil && (job.pages == 0 || !errors.As(job.err, &exitErr) || exitErr.ExitCode() != saneStatusNoDocs) {
+				log.Printf("Err: %s | %s", job.err, job.stderr.String())
+				return nil, fmt.Errorf("scanimage failed after %d pages: %w", job.pages, job.err)
+			}
+			return nil, io.EOF
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Cancel kills the scanimage process
+func (job *saneJob) Cancel() error {
+	select {
+	case <-job.done:
+		return nil
+	default:
+	}
+	if err := job.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-job.done
+	return os.RemoveAll(job.dir)
+}
@@ -1,6 +1,9 @@
 package main
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+	"slices"
+)
 
 type ScannerCapabilities struct {
 	XMLName xml.Name `xml:"ScannerCapabilities"`
@@ -58,20 +61,20 @@ type SupportedResolutions struct {
 }
 
 type DiscreteResolution struct {
-	XResolution int `xml:"XResolution"`
-	YResolution int `xml:"YResolution"`
+	XResolution int `xml:"XResolution" json:"x"`
+	YResolution int `xml:"YResolution" json:"y"`
 }
 
 type ResolutionRange struct {
-	XResolutionRange ResolutionAxis `xml:"XResolutionRange"`
-	YResolutionRange ResolutionAxis `xml:"YResolutionRange"`
+	XResolutionRange ResolutionAxis `xml:"XResolutionRange" json:"x"`
+	YResolutionRange ResolutionAxis `xml:"YResolutionRange" json:"y"`
 }
 
 type ResolutionAxis struct {
-	Min    int `xml:"Min"`
-	Max    int `xml:"Max"`
-	Normal int `xml:"Normal"`
-	Step   int `xml:"Step"`
+	Min    int `xml:"Min" json:"min"`
+	Max    int `xml:"Max" json:"max"`
+	Normal int `xml:"Normal" json:"normal"`
+	Step   int `xml:"Step" json:"step"`
 }
 
 /* ---------------- Color / Channels ---------------- */
@@ -107,6 +110,7 @@ type PlatenInputCaps struct {
 	SettingProfiles SettingProfiles `xml:"SettingProfiles"`
 
 	SupportedResolutions SupportedResolutions `xml:"SupportedResolutions"`
+	SupportedIntents []string `xml:"SupportedIntents>Intent"`
 
 	MaxOpticalXResolution int `xml:"MaxOpticalXResolution"`
 	MaxOpticalYResolution int `xml:"MaxOpticalYResolution"`
@@ -121,10 +125,18 @@ type PlatenInputCaps struct {
 
 type Adf struct {
 	SimplexInputCaps AdfSimplexInputCaps `xml:"AdfSimplexInputCaps"`
+	DuplexInputCaps  *AdfDuplexInputCaps `xml:"AdfDuplexInputCaps"`
 	FeederCapacity   int `xml:"FeederCapacity"`
 	AdfOptions       []string `xml:"AdfOptions>AdfOption"`
 }
 
+// SupportsDuplex reports whether the ADF scans both sides in one
+// pass. Devices announce it by the AdfDuplexInputCaps or the
+// Duplex AdfOption.
+func (adf *Adf) SupportsDuplex() bool {
+	return adf.DuplexInputCaps != nil || slices.Contains(adf.AdfOptions, "Duplex")
+}
+
 type AdfSimplexInputCaps struct {
 	MinWidth        int `xml:"MinWidth"`
 	MaxWidth        int `xml:"MaxWidth"`
@@ -134,6 +146,7 @@ type AdfSimplexInputCaps struct {
 	SettingProfiles SettingProfiles `xml:"SettingProfiles"`
 
 	EdgeAutoDetection EdgeAutoDetection `xml:"EdgeAutoDetection"`
+	SupportedIntents []string `xml:"SupportedIntents>Intent"`
 
 	MaxOpticalXResolution int `xml:"MaxOpticalXResolution"`
 	MaxOpticalYResolution int `xml:"MaxOpticalYResolution"`
@@ -144,6 +157,9 @@ type AdfSimplexInputCaps struct {
 	RiskyBottomMargin int `xml:"RiskyBottomMargin"`
 }
 
+// AdfDuplexInputCaps has the same Elements as AdfSimplexInputCaps
+type AdfDuplexInputCaps = AdfSimplexInputCaps
+
 type EdgeAutoDetection struct {
 	SupportedEdges []string `xml:"SupportedEdge"`
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ScannerStatus is returned by the Scanner Status Interface
+// as specified in Chapter 8.3 in the MopriaSCANT-Spec V.2.97
+type ScannerStatus struct {
+	XMLName xml.Name `xml:"ScannerStatus" json:"-"`
+
+	Version  string       `xml:"Version" json:"version"`
+	State    ScannerState `xml:"State" json:"state"`
+	AdfState AdfState     `xml:"AdfState" json:"adf_state"`
+
+	Jobs []JobInfo `xml:"Jobs>JobInfo" json:"jobs"`
+}
+
+type JobInfo struct {
+	JobUri           string   `xml:"JobUri" json:"job_uri"`
+	JobUuid          string   `xml:"JobUuid" json:"job_uuid"`
+	Age              int      `xml:"Age" json:"age"`
+	ImagesCompleted  int      `xml:"ImagesCompleted" json:"images_completed"`
+	ImagesToTransfer int      `xml:"ImagesToTransfer" json:"images_to_transfer"`
+	JobState         string   `xml:"JobState" json:"job_state"`
+	JobStateReasons  []string `xml:"JobStateReasons>JobStateReason" json:"job_state_reasons"`
+}
+
+/* ---------------- States ---------------- */
+
+// ScannerState is the pwg:State of a Device
+type ScannerState string
+
+const (
+	ScannerStateIdle       ScannerState = "Idle"
+	ScannerStateProcessing ScannerState = "Processing"
+	ScannerStateTesting    ScannerState = "Testing"
+	ScannerStateStopped    ScannerState = "Stopped"
+	ScannerStateDown       ScannerState = "Down"
+)
+
+// AdfState is the scan:AdfState of a Device
+type AdfState string
+
+const (
+	AdfStateProcessing          AdfState = "ScannerAdfProcessing"
+	AdfStateEmpty               AdfState = "ScannerAdfEmpty"
+	AdfStateJam                 AdfState = "ScannerAdfJam"
+	AdfStateLoaded              AdfState = "ScannerAdfLoaded"
+	AdfStateMispick             AdfState = "ScannerAdfMispick"
+	AdfStateHatchOpen           AdfState = "ScannerAdfHatchOpen"
+	AdfStateDuplexPageTooShort  AdfState = "ScannerAdfDuplexPageTooShort"
+	AdfStateDuplexPageTooLong   AdfState = "ScannerAdfDuplexPageTooLong"
+	AdfStateMultipickDetected   AdfState = "ScannerAdfMultipickDetected"
+	AdfStateInputTrayFailed     AdfState = "ScannerAdfInputTrayFailed"
+	AdfStateInputTrayOverloaded AdfState = "ScannerAdfInputTrayOverloaded"
+)
+
+// IsBusy reports whether the Device is processing a Job
+func (ss *ScannerStatus) IsBusy() bool {
+	return ss.State == ScannerStateProcessing || ss.State == ScannerStateTesting
+}
+
+// IsDown reports whether the Device cant accept Jobs at all
+func (ss *ScannerStatus) IsDown() bool {
+	return ss.State == ScannerStateStopped || ss.State == ScannerStateDown
+}
+
+// IsAdfEmpty reports whether the ADF has no paper loaded.
+// Devices not reporting an AdfState are considered loaded.
+func (ss *ScannerStatus) IsAdfEmpty() bool {
+	return ss.AdfState == AdfStateEmpty
+}
+
+// IsAdfJammed reports whether the ADF needs manual intervention
+func (ss *ScannerStatus) IsAdfJammed() bool {
+	return slices.Contains([]AdfState{
+		AdfStateJam,
+		AdfStateMispick,
+		AdfStateHatchOpen,
+		AdfStateDuplexPageTooShort,
+		AdfStateDuplexPageTooLong,
+		AdfStateMultipickDetected,
+		AdfStateInputTrayFailed,
+		AdfStateInputTrayOverloaded,
+	}, ss.AdfState)
+}
+
+// Status queries the Scanner Status Interface of the eSCL-Device
+func (sd *ScanDevice) Status() (*ScannerStatus, error) {
+	base, err := sd.baseURL()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sd.httpClient().Get(base.JoinPath("ScannerStatus").String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ScannerStatus failed: Status: %d - %s", resp.StatusCode, resp.Status)
+	}
+	var status ScannerStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
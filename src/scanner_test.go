@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newFakeScanimage writes a scanimage scanning the given amount of
+// Pages into the batch before it exits with status
+func newFakeScanimage(t *testing.T, pages int, status int) *saneScanner {
+	bin := filepath.Join(t.TempDir(), "scanimage")
+	script := fmt.Sprintf(`#!/bin/sh
+for arg; do
+	case $arg in --batch=*) batch=${arg#--batch=};; esac
+done
+n=1
+while [ $n -le %d ]; do
+	printf page > $(printf "$batch" $n)
+	n=$((n+1))
+done
+exit %d
+`, pages, status)
+	if err := os.WriteFile(bin, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &saneScanner{device: &ScanDevice{Id: "flatbed", Backend: BackendSane, SaneDevice: "test:0"}, bin: bin}
+}
+
+func TestSaneJobFailsMidBatch(t *testing.T) {
+	for _, tc := range []struct {
+		pages int
+		status int
+		ok bool
+	}{
+		{2, 0, true},
+		// the ADF ran empty after the last Page
+		{2, saneStatusNoDocs, true},
+		// e.g. a Paper Jam (SANE_STATUS_JAMMED)
+		{2, 6, false},
+		{0, saneStatusNoDocs, false},
+	} {
+		job, err := newFakeScanimage(t, tc.pages, tc.status).StartJob(&ScanSettingsDto{InputSource: "ADF", ColorMode: ColorModeGray})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := 0
+		for {
+			_, err = job.NextPage()
+			if err != nil {
+				break
+			}
+			pages++
+		}
+		if tc.ok != (err == io.EOF) || pages != tc.pages {
+			t.Fatalf("%d pages, status %d: unexpected result after %d pages: %v", tc.pages, tc.status, pages, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+)
+
+// width of the Thumbnails in Pixel
+const thumbnailWidth = 160
+
+// thumbnail renders a scanned Page into a small JPEG,
+// returned as data URI. PDF Pages are previewed by their
+// embedded JPEG, see pdfPageImage.
+func thumbnail(page *ScanPage) (string, error) {
+
+	data := page.Data
+	if pageExt(page) == ".pdf" {
+		var err error
+		if data, err = pdfPageImage(page.Data); err != nil {
+			return "", err
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("cant decode %s: %w", page.ContentType, err)
+	}
+
+	src := img.Bounds()
+	if src.Dx() == 0 || src.Dy() == 0 {
+		return "", fmt.Errorf("empty image")
+	}
+
+	// nearest neighbour is good enough for a preview
+	w := min(thumbnailWidth, src.Dx())
+	h := max(1, src.Dy()*w/src.Dx())
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			thumb.Set(x, y, img.At(src.Min.X+x*src.Dx()/w, src.Min.Y+y*src.Dy()/h))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 60}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// pdfPageImage returns the first JPEG (DCTDecode) Image of the
+// PDF. Devices put the scanned Page as JPEG into their PDFs,
+// other PDFs cant be previewed without rendering them.
+func pdfPageImage(pdf []byte) ([]byte, error) {
+	filter := bytes.Index(pdf, []byte("/DCTDecode"))
+	if filter < 0 {
+		return nil, fmt.Errorf("no JPEG image in PDF")
+	}
+	// the Stream follows the Dictionary holding the Filter
+	stream := bytes.Index(pdf[filter:], []byte("stream"))
+	if stream < 0 {
+		return nil, fmt.Errorf("no JPEG image in PDF")
+	}
+	data := bytes.TrimLeft(pdf[filter+stream+len("stream"):], "\r\n")
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return nil, fmt.Errorf("no JPEG image in PDF")
+	}
+	return data, nil
+}
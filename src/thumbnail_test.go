@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestThumbnailOfPdfPage(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewGray(image.Rect(0, 0, 400, 600))
+	for n := range img.Pix {
+		img.Pix[n] = uint8(n)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	page := filepath.Join(dir, "0001.jpg")
+	if err := os.WriteFile(page, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a PDF as delivered by the Device, the Page is a JPEG
+	scanned := filepath.Join(dir, "scanned.pdf")
+	if err := pagesToPDF([]string{page}, nil, 0, 0, 75, scanned); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(scanned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := thumbnail(&ScanPage{ContentType: "application/pdf", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(thumb, "data:image/jpeg;base64,") {
+		t.Fatalf("unexpected thumbnail %.40s", thumb)
+	}
+
+	// PDFs without JPEG have no preview
+	text := filepath.Join(dir, "text.pdf")
+	writeTestPDF(t, text, 1)
+	if data, err = os.ReadFile(text); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thumbnail(&ScanPage{ContentType: "application/pdf", Data: data}); err == nil {
+		t.Fatal("expected PDF without image to have no thumbnail")
+	}
+}
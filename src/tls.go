@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TlsConfig defines how the Certificate of an eSCL-Device is
+// trusted. Devices use self-signed Certificates, so the usual
+// Verification against the System Roots fails.
+type TlsConfig struct {
+	// SHA-256 Fingerprint of the Device Certificate, hex encoded.
+	// If neither Fingerprint nor CaFile is set, the Certificate
+	// presented first is pinned (trust-on-first-use).
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// PEM-File of the CA the Device Certificate is issued by
+	CaFile string `json:"ca_file,omitempty"`
+}
+
+// TrustStore remembers the Fingerprints pinned on first use by
+// Device Id. Pins are persisted to file, if one is configured.
+type TrustStore struct {
+	mu sync.Mutex
+	file string
+	pins map[string]string
+}
+
+// NewTrustStore loads the pinned Fingerprints from file.
+// An empty file keeps the pins in memory only.
+func NewTrustStore(file string) (*TrustStore, error) {
+	ts := &TrustStore{file: file, pins: map[string]string{}}
+	if file == "" {
+		return ts, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ts.pins); err != nil {
+		return nil, fmt.Errorf("invalid trust store %s: %w", file, err)
+	}
+	return ts, nil
+}
+
+// verify pins the Fingerprint on first use, afterwards
+// only the pinned Fingerprint is accepted
+func (ts *TrustStore) verify(deviceId string, fingerprint string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if pinned, ok := ts.pins[deviceId]; ok {
+		if pinned != fingerprint {
+			return fmt.Errorf("certificate of %s changed: pinned %s, got %s", deviceId, pinned, fingerprint)
+		}
+		return nil
+	}
+
+	log.Println("pinning certificate of", deviceId, fingerprint)
+	ts.pins[deviceId] = fingerprint
+	if ts.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ts.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(ts.file, data, 0600); err != nil {
+		log.Printf("Err: cant persist trust store: %s", err)
+	}
+	return nil
+}
+
+// fingerprint returns the SHA-256 Fingerprint of a DER Certificate
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts Fingerprints as printed by
+// openssl, e.g. "AB:CD:..."
+func normalizeFingerprint(f string) string {
+	return strings.ToLower(strings.ReplaceAll(f, ":", ""))
+}
+
+// newTlsClient creates the Client for an eSCL-Device reachable via HTTPS.
+// The Hostname is never verified, Devices are addressed by IP.
+func newTlsClient(sd *ScanDevice, ts *TrustStore) (*http.Client, error) {
+
+	tlsConfig := sd.Tls
+	if tlsConfig == nil {
+		tlsConfig = &TlsConfig{}
+	}
+
+	var verify func(certs []*x509.Certificate) error
+	switch {
+	case tlsConfig.CaFile != "":
+		pem, err := os.ReadFile(tlsConfig.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsConfig.CaFile)
+		}
+		verify = func(certs []*x509.Certificate) error {
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		}
+	case tlsConfig.Fingerprint != "":
+		pinned := normalizeFingerprint(tlsConfig.Fingerprint)
+		verify = func(certs []*x509.Certificate) error {
+			if got := fingerprint(certs[0].Raw); got != pinned {
+				return fmt.Errorf("certificate fingerprint mismatch: expected %s, got %s", pinned, got)
+			}
+			return nil
+		}
+	default:
+		verify = func(certs []*x509.Certificate) error {
+			return ts.verify(sd.Id, fingerprint(certs[0].Raw))
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		// replaced by VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no certificate presented")
+			}
+			return verify(cs.PeerCertificates)
+		},
+	}
+	return &http.Client{Transport: transport}, nil
+}
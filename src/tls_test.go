@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTlsFakeScanner(t *testing.T) *httptest.Server {
+	caps, err := os.ReadFile(path.Join("testdata", "caps.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(caps)
+	}))
+}
+
+func TestTlsDeviceIsPinnedOnFirstUse(t *testing.T) {
+	server := newTlsFakeScanner(t)
+	defer server.Close()
+
+	ts, err := NewTrustStore(path.Join(t.TempDir(), "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := &ScanDevice{Id: "tls", URL: server.URL + "/eSCL"}
+	if err := dev.initClient(ts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScanDevice(dev.httpClient(), dev.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pin has to survive a restart
+	ts, err = NewTrustStore(ts.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.pins["tls"] != fingerprint(server.Certificate().Raw) {
+		t.Fatalf("certificate not pinned: %v", ts.pins)
+	}
+
+	// httptest servers share their certificate, so change the pin instead
+	ts.pins["tls"] = "00"
+	if err := dev.initClient(ts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScanDevice(dev.httpClient(), dev.URL); err == nil {
+		t.Fatal("expected changed certificate to be rejected")
+	}
+}
+
+func TestTlsDeviceWithFingerprint(t *testing.T) {
+	server := newTlsFakeScanner(t)
+	defer server.Close()
+
+	dev := &ScanDevice{Id: "tls", URL: server.URL + "/eSCL", Tls: &TlsConfig{Fingerprint: "00:11"}}
+	if err := dev.initClient(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScanDevice(dev.httpClient(), dev.URL); err == nil {
+		t.Fatal("expected fingerprint mismatch")
+	}
+
+	dev.Tls.Fingerprint = fingerprint(server.Certificate().Raw)
+	if err := dev.initClient(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScanDevice(dev.httpClient(), dev.URL); err != nil {
+		t.Fatal(err)
+	}
+}
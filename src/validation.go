@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// supportsResolution reports whether x and y DPI are listed
+// or within the ResolutionRange
+func (c *InputSourceCaps) supportsResolution(x, y int) bool {
+	if len(c.Resolutions) == 0 && c.ResolutionRange == nil {
+		return true
+	}
+	if slices.Contains(c.Resolutions, DiscreteResolution{XResolution: x, YResolution: y}) {
+		return true
+	}
+	if r := c.ResolutionRange; r != nil {
+		return r.XResolutionRange.contains(x) && r.YResolutionRange.contains(y)
+	}
+	return false
+}
+
+// resolutionField names the Field of an unsupported Resolution:
+// the Axis not supported at all, else the Combination of both
+func (c *InputSourceCaps) resolutionField(x, y int) string {
+	xOk, yOk := false, false
+	for _, res := range c.Resolutions {
+		xOk = xOk || res.XResolution == x
+		yOk = yOk || res.YResolution == y
+	}
+	if r := c.ResolutionRange; r != nil {
+		xOk = xOk || r.XResolutionRange.contains(x)
+		yOk = yOk || r.YResolutionRange.contains(y)
+	}
+	switch {
+	case !xOk:
+		return "XResolution"
+	case !yOk:
+		return "YResolution"
+	}
+	return "Resolution"
+}
+
+func (axis ResolutionAxis) contains(v int) bool {
+	if v < axis.Min || v > axis.Max {
+		return false
+	}
+	return axis.Step <= 0 || (v-axis.Min)%axis.Step == 0
+}
+
+// validate checks the dto against the Capabilities of its InputSource
+func (c *InputSourceCaps) validate(dto *ScanSettingsDto) ValidationErrors {
+	var errs ValidationErrors
+	if !slices.Contains(c.ColorModes, dto.ColorMode) {
+		errs.add("ColorMode", "unsupported ColorMode %s, supported are: %s", dto.ColorMode, joinColorModes(c.ColorModes))
+	}
+	if len(c.DocumentFormats) > 0 && !slices.Contains(c.DocumentFormats, dto.DocumentFormat) {
+		errs.add("DocumentFormat", "unsupported DocumentFormat %s, supported are: %s", dto.DocumentFormat, strings.Join(c.DocumentFormats, ","))
+	}
+	if dto.Intent != "" && len(c.Intents) > 0 && !slices.Contains(c.Intents, dto.Intent) && slices.Contains(scanIntents, dto.Intent) {
+		errs.add("Intent", "unsupported Intent %s, supported are: %s", dto.Intent, strings.Join(c.Intents, ","))
+	}
+	if !c.supportsResolution(dto.XResolution, dto.YResolution) {
+		errs.add(c.resolutionField(dto.XResolution, dto.YResolution), "unsupported resolution %dx%d, supported are: %s", dto.XResolution, dto.YResolution, c.resolutionsString())
+	}
+	if dto.Width < c.MinWidth || dto.Width > c.MaxWidth {
+		errs.add("Width", "width %d out of range %d-%d", dto.Width, c.MinWidth, c.MaxWidth)
+	}
+	if dto.Height < c.MinHeight || dto.Height > c.MaxHeight {
+		errs.add("Height", "height %d out of range %d-%d", dto.Height, c.MinHeight, c.MaxHeight)
+	}
+	if dto.XOffset < 0 || dto.XOffset+dto.Width > c.MaxWidth {
+		errs.add("XOffset", "region %d+%d exceeds max width %d", dto.XOffset, dto.Width, c.MaxWidth)
+	}
+	if dto.YOffset < 0 || dto.YOffset+dto.Height > c.MaxHeight {
+		errs.add("YOffset", "region %d+%d exceeds max height %d", dto.YOffset, dto.Height, c.MaxHeight)
+	}
+	return errs
+}
+
+func (c *InputSourceCaps) resolutionsString() string {
+	supported := []string{}
+	for _, res := range c.Resolutions {
+		supported = append(supported, fmt.Sprintf("%dx%d", res.XResolution, res.YResolution))
+	}
+	if r := c.ResolutionRange; r != nil {
+		supported = append(supported, fmt.Sprintf("%d-%d step %d", r.XResolutionRange.Min, r.XResolutionRange.Max, r.XResolutionRange.Step))
+	}
+	return strings.Join(supported, ",")
+}
+
+// ValidationError reports an invalid Field of the ScanSettingsDto
+type ValidationError struct {
+	Field string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors are all invalid Fields of a ScanSettingsDto,
+// the API surfaces them Field by Field
+type ValidationErrors []ValidationError
+
+func (ve ValidationErrors) Error() string {
+	messages := make([]string, len(ve))
+	for i, err := range ve {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (ve *ValidationErrors) add(field string, format string, args ...any) {
+	*ve = append(*ve, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
@@ -1,13 +1,42 @@
 import React, { useState, useEffect } from "react";
 import { createRoot } from "react-dom/client";
-import { CheckboxGroup, Checkbox, InlineLoading, Grid, Heading, Stack, Column, Form, Theme, TextInput, Button, InlineNotification } from "@carbon/react";
+import { CheckboxGroup, Checkbox, Select, SelectItem, InlineLoading, Grid, Heading, Stack, Column, Form, Theme, TextInput, Button, InlineNotification } from "@carbon/react";
 import "@carbon/styles/css/styles.css";
 
+// resolves with the final job once the event stream ends
+function followJob(job, onEvent) {
+  return new Promise((resolve) => {
+    const source = new EventSource("/api/jobs/" + job.id + "/events");
+    const onMessage = (e) => {
+      const event = JSON.parse(e.data);
+      onEvent(event);
+      if (!stateLabels[event.status.state]) {
+        source.close();
+        resolve(event.status);
+      }
+    };
+    ["state", "queue", "page"].forEach((type) => source.addEventListener(type, onMessage));
+  });
+}
+
+const stateLabels = {
+  queued: "wartet...",
+  scanning: "scanne...",
+  waiting_for_flip: "Stapel wenden und fortsetzen!",
+  processing: "erstelle PDF...",
+  delivering: "versende...",
+};
+
 function ScanbridgeApp() {
   const [recipient, setRecipient] = useState("");
   const [colorMode, setColorMode] = useState(true);
+  const [manualDuplex, setManualDuplex] = useState(false);
+  const [intent, setIntent] = useState("Document");
+  const [ocrLanguage, setOcrLanguage] = useState("");
   const [loading, setLoading] = useState(true);
   const [notification, setNotification] = useState({});
+  const [job, setJob] = useState(null);
+  const [thumbnail, setThumbnail] = useState(null);
 
   useEffect(() => {
     async function fetchInitialValue() {
@@ -30,24 +59,53 @@ function ScanbridgeApp() {
     setNotification({});
     setLoading(true);
     try {
-      const mode = colorMode === true ? "Color" : "Lineart";
-      const res = await fetch(
-        "/api/scan?mode=" + encodeURIComponent(mode) + "&recipient=" + encodeURIComponent(recipient)
-      );
-      const data = await res.json();
+      const mode = colorMode === true ? "color" : "bw";
+      const res = await fetch("/api/jobs", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ mode: mode, intent: intent, manual_duplex: manualDuplex, ocr_language: ocrLanguage }),
+      });
+      let data = await res.json();
       if (!res.ok) {
         setNotification({data: data.Data, kind: "error", title: data.Title});
       } else {
-        setNotification({data: data.Data, kind: "success", title: data.Title, url: data.url});
+        setJob(data);
+        data = await followJob(data, (event) => {
+          setJob(event.status);
+          if (event.thumbnail) {
+            setThumbnail(event.thumbnail);
+          }
+        });
+        if (data.state === "done") {
+          setNotification({data: "Der Scan war erfolgreich!", kind: "success", title: "OK!", url: data.url});
+        } else if (data.state === "cancelled") {
+          setNotification({data: "Der Scan wurde abgebrochen.", kind: "warning", title: "Abgebrochen!"});
+        } else {
+          setNotification({data: "Prüfe, ob der Scanner eingeschalten ist (Ein/Aus-Taste darf nicht blinken) und Papier im Schnelleinzug liegt. Beim Einlegen des Papiers wird der Scanner ein kurzen Ton wiedergeben.", kind: "error", title: "Scan kann nicht ausgeführt werden!"});
+        }
       }
 
     } catch (err) {
       console.error(err);
       setNotification({data: "Das hat nicht geklappt! :(", kind: "error", title: "KO!"});
     }
+    setJob(null);
+    setThumbnail(null);
     setLoading(false);
   };
 
+  const onContinue = async () => {
+    if (job) {
+      await fetch("/api/jobs/" + job.id + "/continue", { method: "POST" });
+    }
+  };
+
+  const onCancel = async () => {
+    if (job) {
+      await fetch("/api/jobs/" + job.id, { method: "DELETE" });
+    }
+  };
+
   return (
   <Theme theme="g10">
     <Grid>
@@ -69,7 +127,35 @@ function ScanbridgeApp() {
                   labelText="Farbscan (langsamer)?"
                   onChange={(e) => setColorMode(e.target.checked)}
                 />
+                <Checkbox
+                  id="checkbox-manual-duplex"
+                  value={manualDuplex}
+                  checked={manualDuplex}
+                  labelText="Beidseitig (Stapel nach den Vorderseiten wenden)?"
+                  onChange={(e) => setManualDuplex(e.target.checked)}
+                />
               </CheckboxGroup>
+              <Select
+                id="select-intent"
+                labelText="Inhalt"
+                value={intent}
+                onChange={(e) => setIntent(e.target.value)}
+              >
+                <SelectItem value="Document" text="Dokument" />
+                <SelectItem value="TextAndGraphic" text="Text und Grafik" />
+                <SelectItem value="Photo" text="Foto" />
+              </Select>
+              <Select
+                id="select-ocr-language"
+                labelText="Texterkennung (durchsuchbares PDF)"
+                value={ocrLanguage}
+                onChange={(e) => setOcrLanguage(e.target.value)}
+              >
+                <SelectItem value="" text="keine" />
+                <SelectItem value="deu" text="Deutsch" />
+                <SelectItem value="eng" text="Englisch" />
+                <SelectItem value="deu+eng" text="Deutsch und Englisch" />
+              </Select>
               <TextInput
                 id="simple-input"
                 labelText="Empfänger E-Mail"
@@ -90,8 +176,11 @@ function ScanbridgeApp() {
               <Stack orientation="horizontal" gap={4}>
               {loading ? <InlineLoading
                 status="active"
-                description="scanne..."
+                description={job ? (job.state === "queued" ? `wartet auf den Scanner (${job.queue_position} vor dir)...` : `${stateLabels[job.state] ?? ""} ${job.pages} Seite(n)`) : "scanne..."}
               /> : <Button type="submit">bitti bitti Scani!</Button>}
+              {thumbnail && <img src={thumbnail} alt={`Seite ${job?.pages}`} />}
+              {job?.state === "waiting_for_flip" && <Button onClick={onContinue}>Stapel gewendet, weiter</Button>}
+              {job && <Button kind="danger--tertiary" onClick={onCancel}>Abbrechen</Button>}
               {notification?.url && <Button kind="secondary" onClick={() => window.location.href = notification.url}>Download</Button>}
             </Stack>
 